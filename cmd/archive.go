@@ -0,0 +1,589 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive formats of the comdir and comfile commands, the dcdir and dcfile commands detect them by their magic bytes.
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatTarXz  = "tar.xz"
+	FormatTarLz4 = "tar.lz4"
+	FormatTarBz2 = "tar.bz2" // Read-only
+	FormatTar    = "tar"     // Read-only
+	FormatZip    = "zip"
+)
+
+// archiveFormat is a supported archive format, the tar formats differ only by their compression codec.
+type archiveFormat struct {
+	name     string
+	magic    []byte
+	offset   int // Offset of the magic bytes
+	minLevel int // 0 when the format has no compression levels
+	maxLevel int
+	tar      bool
+	threads  bool // Supports the multi-threaded compression
+	encode   func(w io.Writer, level, threads int) (io.WriteCloser, error) // nil when the format is read-only
+	decode   func(r io.Reader) (io.ReadCloser, error)
+}
+
+// gzipBlockSize is the size of the blocks compressed in parallel by the multi-threaded gzip.
+const gzipBlockSize = 1 << 20
+
+// xzDictCaps are the dictionary sizes of the xz levels 1 to 9, from 1 MiB up to 64 MiB, 0 is the xz package default.
+var xzDictCaps = [...]int{0, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// archiveFormats lists the supported formats in the order their magic bytes are checked.
+var archiveFormats = []*archiveFormat{
+	{name: FormatTarGz, magic: []byte{0x1f, 0x8b}, minLevel: gzip.BestSpeed, maxLevel: gzip.BestCompression, tar: true, threads: true,
+		encode: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			if threads == 1 {
+				return gzip.NewWriterLevel(w, level)
+			}
+			// The blocks are compressed in parallel pigz-style, into a single gzip stream any gzip tool can read.
+			zw, err := pgzip.NewWriterLevel(w, level)
+			if err != nil {
+				return nil, err
+			}
+			return zw, zw.SetConcurrency(gzipBlockSize, 2*threads)
+		},
+		decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	{name: FormatTarZst, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, minLevel: 1, maxLevel: 22, tar: true, threads: true,
+		encode: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(threads)}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+		decode: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{name: FormatTarXz, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, minLevel: 1, maxLevel: 9, tar: true,
+		encode: func(w io.Writer, level, threads int) (io.WriteCloser, error) {
+			// The levels pick the dictionary size the same way as the xz tool presets.
+			return xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(w)
+		},
+		decode: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(xr), nil
+		},
+	},
+	{name: FormatTarLz4, magic: []byte{0x04, 0x22, 0x4d, 0x18}, tar: true,
+		encode: func(w io.Writer, level, threads int) (io.WriteCloser, error) { return lz4.NewWriter(w), nil },
+		decode: func(r io.Reader) (io.ReadCloser, error) {
+			// Only its Read method is kept, the WriteTo method of lz4.Reader fails once Read was called.
+			return ioutil.NopCloser(struct{ io.Reader }{lz4.NewReader(r)}), nil
+		},
+	},
+	{name: FormatTarBz2, magic: []byte{'B', 'Z', 'h'}, tar: true,
+		decode: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(bzip2.NewReader(r)), nil },
+	},
+	{name: FormatZip, magic: []byte{'P', 'K', 0x03, 0x04}, minLevel: flate.BestSpeed, maxLevel: flate.BestCompression},
+	{name: FormatTar, magic: []byte("ustar"), offset: 257, tar: true,
+		decode: func(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(r), nil },
+	},
+}
+
+// writableFormats returns the names of the formats that comdir and comfile can write.
+func writableFormats() []string {
+	var names []string
+	for _, f := range archiveFormats {
+		if f.encode != nil || f.name == FormatZip {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// archiveFormatFor returns the writable archive format by its name, and checks the compression level and
+// the number of threads are supported by it, the level 0 is the format's default.
+func archiveFormatFor(name string, level, threads int) (*archiveFormat, error) {
+	name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), ".")
+	for _, f := range archiveFormats {
+		if f.name != name {
+			continue
+		}
+		if f.encode == nil && f.name != FormatZip {
+			return nil, fmt.Errorf("the %s format is read-only, use one of: %s", name, strings.Join(writableFormats(), ", "))
+		}
+		if level != 0 && f.maxLevel == 0 {
+			return nil, fmt.Errorf("the %s format has no compression levels", name)
+		}
+		if level != 0 && (level < f.minLevel || level > f.maxLevel) {
+			return nil, fmt.Errorf("--level must be from %d to %d for the %s format", f.minLevel, f.maxLevel, name)
+		}
+		if threads < 1 {
+			return nil, fmt.Errorf("--threads must be at least 1")
+		}
+		if threads > 1 && !f.threads {
+			return nil, fmt.Errorf("the %s format has no multi-threaded compression, only %s and %s have", name, FormatTarGz, FormatTarZst)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q, use one of: %s", name, strings.Join(writableFormats(), ", "))
+}
+
+// detectArchiveFormat returns the archive format of the file from its magic bytes, regardless of its file extension.
+func detectArchiveFormat(file string) (*archiveFormat, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	for _, af := range archiveFormats {
+		if len(head) >= af.offset+len(af.magic) && bytes.Equal(head[af.offset:af.offset+len(af.magic)], af.magic) {
+			return af, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown archive format", file)
+}
+
+// compressOptions are the --format, --level and --threads flags of the comdir and comfile commands.
+type compressOptions struct {
+	format  string // Empty for the format setting of the 'config.yaml' file
+	level   int    // 0 for the default.compression_level setting
+	threads int    // 0 for the default.compression_threads setting, -1 for all the CPUs
+}
+
+// addFormatFlags registers the --format, --level and --threads flags of the comdir and comfile commands.
+func addFormatFlags(cmd *cobra.Command, opts *compressOptions, configKey string) {
+	cmd.Flags().StringVar(&opts.format, "format", "", fmt.Sprintf("archive format, one of: %s (default from %s)", strings.Join(writableFormats(), ", "), configKey))
+	cmd.Flags().IntVar(&opts.level, "level", 0, "compression level of the format, 0 is the format's default (default from default.compression_level)")
+	cmd.Flags().IntVar(&opts.threads, "threads", 0, "number of threads of the tar.gz and tar.zst compression, -1 for all the CPUs (default from default.compression_threads)")
+}
+
+// resolveFormat returns the archive format, level and threads, the 'config.yaml' defaults apply when they're not set.
+// The default.compression_level and default.compression_threads settings only apply to the formats that support them.
+func resolveFormat(opts compressOptions, configKey string) (*archiveFormat, int, int, error) {
+	format, level, threads := opts.format, opts.level, opts.threads
+	if format == "" {
+		format = viper.GetString(configKey)
+	}
+	f, err := archiveFormatFor(format, level, 1)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if level == 0 && f.maxLevel != 0 {
+		level = viper.GetInt("default.compression_level")
+	}
+	if threads == 0 && f.threads {
+		threads = viper.GetInt("default.compression_threads")
+	}
+	if threads == -1 {
+		threads = runtime.NumCPU()
+	}
+	if threads == 0 {
+		threads = 1
+	}
+	f, err = archiveFormatFor(format, level, threads)
+	return f, level, threads, err
+}
+
+// archiveWriter adds the folders, files and symlinks into an archive, the names are slash separated.
+type archiveWriter interface {
+	add(name string, fi os.FileInfo, link string, r io.Reader) error
+	Close() error
+}
+
+// newArchiveWriter returns the archive writer of the format into the w writer.
+func newArchiveWriter(w io.Writer, format *archiveFormat, level, threads int) (archiveWriter, error) {
+	if !format.tar {
+		zw := zip.NewWriter(w)
+		if level != 0 {
+			zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) { return flate.NewWriter(out, level) })
+		}
+		return &zipArchiveWriter{zw: zw}, nil
+	}
+	codec, err := format.encode(w, level, threads)
+	if err != nil {
+		return nil, err
+	}
+	return &tarArchiveWriter{tw: tar.NewWriter(codec), codec: codec}, nil
+}
+
+// tarArchiveWriter writes a tar stream into its compression codec.
+type tarArchiveWriter struct {
+	tw    *tar.Writer
+	codec io.WriteCloser
+}
+
+func (a *tarArchiveWriter) add(name string, fi os.FileInfo, link string, r io.Reader) error {
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if fi.IsDir() {
+		header.Name += "/"
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if r == nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	_, err = io.Copy(a.tw, r)
+	return err
+}
+
+// Close produces the tar container first, then finishes the compression codec.
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.codec.Close()
+}
+
+// zipArchiveWriter writes a zip archive with the deflate compression.
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) add(name string, fi os.FileInfo, link string, r io.Reader) error {
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	if fi.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	}
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		// The zip format keeps the symlink target as the content of the entry.
+		_, err = io.WriteString(w, link)
+	case r != nil && fi.Mode().IsRegular():
+		_, err = io.Copy(w, r)
+	}
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// archiveEntry is a folder, file or symlink read from an archive, its name is slash separated as it's stored.
+type archiveEntry struct {
+	name    string
+	mode    os.FileMode // The os.ModeDir or os.ModeSymlink type bits are set, os.ModeIrregular for the unsupported types
+	modTime time.Time
+	size    int64
+	link    string
+}
+
+// archiveReader reads the entries of an archive in order.
+type archiveReader interface {
+	// next returns the next entry and the reader of its content, or io.EOF after the last entry.
+	next() (*archiveEntry, io.Reader, error)
+	Close() error
+}
+
+// openArchive opens the archive file, its format is detected from its magic bytes.
+func openArchive(file string) (archiveReader, *archiveFormat, error) {
+	format, err := detectArchiveFormat(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !format.tar {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s: %v", file, err)
+		}
+		return &zipArchiveReader{f: f, files: zr.File}, format, nil
+	}
+
+	codec, err := format.decode(bufio.NewReaderSize(f, 1<<20))
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &tarArchiveReader{f: f, codec: codec, tr: tar.NewReader(codec)}, format, nil
+}
+
+// tarArchiveReader reads a tar stream from its decompression codec.
+type tarArchiveReader struct {
+	f     *os.File
+	codec io.ReadCloser
+	tr    *tar.Reader
+}
+
+func (a *tarArchiveReader) next() (*archiveEntry, io.Reader, error) {
+	for {
+		header, err := a.tr.Next()
+		if err == io.EOF {
+			// The rest of the stream is read for the codec to check its trailing checksum, e.g. the gzip CRC-32.
+			if _, err := io.Copy(ioutil.Discard, a.codec); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		e := &archiveEntry{name: header.Name, mode: header.FileInfo().Mode(), modTime: header.ModTime, size: header.Size, link: header.Linkname}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue // The PAX global headers aren't entries.
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink:
+		default:
+			e.mode |= os.ModeIrregular
+		}
+		return e, a.tr, nil
+	}
+}
+
+func (a *tarArchiveReader) Close() error {
+	a.codec.Close()
+	return a.f.Close()
+}
+
+// zipArchiveReader reads the entries of a zip archive in the order of its central directory.
+type zipArchiveReader struct {
+	f     *os.File
+	files []*zip.File
+	cur   io.ReadCloser
+}
+
+func (a *zipArchiveReader) next() (*archiveEntry, io.Reader, error) {
+	if a.cur != nil {
+		a.cur.Close()
+		a.cur = nil
+	}
+	if len(a.files) == 0 {
+		return nil, nil, io.EOF
+	}
+	zf := a.files[0]
+	a.files = a.files[1:]
+
+	e := &archiveEntry{name: zf.Name, mode: zf.Mode(), modTime: zf.Modified, size: int64(zf.UncompressedSize64)}
+	if zf.Modified.IsZero() {
+		e.modTime = zf.ModTime()
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	a.cur = rc
+	if e.mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return nil, nil, err
+		}
+		e.link = string(target)
+	}
+	return e, rc, nil
+}
+
+func (a *zipArchiveReader) Close() error {
+	if a.cur != nil {
+		a.cur.Close()
+	}
+	return a.f.Close()
+}
+
+// archiveName returns the archive file name of the base name in the format, e.g. folder_name.tar.zst.
+func archiveName(base string, format *archiveFormat) string {
+	return base + "." + format.name
+}
+
+// trimArchiveExt removes the archive format's file extension from the file path, or any other file extension.
+func trimArchiveExt(file string) string {
+	lower := strings.ToLower(file)
+	names := make([]string, 0, len(archiveFormats))
+	for _, f := range archiveFormats {
+		names = append(names, f.name)
+	}
+	// The longest first, so ".tar.gz" is removed before ".tar".
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		if strings.HasSuffix(lower, "."+name) {
+			return file[:len(file)-len(name)-1]
+		}
+	}
+	if ext := filepath.Ext(file); ext != "" && ext != file {
+		return strings.TrimSuffix(file, ext)
+	}
+	return file + "_extracted"
+}
+
+// compressDIR streams the entire src folder as an archive of the format into the w writer, the entries
+// are named after the src folder, e.g. "folder_name/sub_folder/file.txt". Unlike kopy.CompressDIR
+// it stops at the first file that can't be read or written. The first entry is the manifest of the files,
+// in the archived folder, e.g. "folder_name/.gokopy-manifest.json".
+func compressDIR(src string, w io.Writer, format *archiveFormat, level, threads int, ignore []string, filter *fileFilter) error {
+	// The manifest is the first entry, so every file is hashed before the archive is written.
+	m, err := dirManifest(src, ignore, filter)
+	if err != nil {
+		return err
+	}
+	aw, err := newArchiveWriter(w, format, level, threads)
+	if err != nil {
+		return err
+	}
+	if err := m.addToArchive(aw, path.Join(filepath.Base(src), ManifestName)); err != nil {
+		return err
+	}
+
+	// Only the files of the manifest are archived, so the filter is applied once and the files created
+	// since it was written don't make the archive differ from it.
+	files := m.lookup()
+	err = walkArchiveTree(src, ignore, nil, func(file, name string, fi os.FileInfo) error {
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			return aw.add(name, fi, link, nil)
+		case fi.Mode().IsRegular():
+			if _, ok := files[name]; !ok {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return aw.add(name, fi, "", f)
+		case fi.IsDir():
+			return aw.add(name, fi, "", nil)
+		}
+		return nil // Devices, sockets and named pipes aren't archived.
+	})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// walkArchiveTree walks the src folder except the ignored files and folders, and the regular files left
+// out by the filter. The name of every file is its slash separated path in the archive, starting with
+// the src base name.
+func walkArchiveTree(src string, ignore []string, filter *fileFilter, fn func(file, name string, fi os.FileInfo) error) error {
+	base := filepath.Base(src)
+	ig := newIgnoreMatcher(src, ignore)
+	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ig.match(file, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() && !filter.keep(fi.Name(), fi) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		return fn(file, path.Join(base, filepath.ToSlash(rel)), fi)
+	})
+}
+
+// writeArchiveAtomic streams the archive written by the write function into a temporary file in the
+// archive's folder and renames it once it's complete, the partial file is deleted on failure.
+func writeArchiveAtomic(archive string, write func(w io.Writer) error) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(archive), "."+filepath.Base(archive)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriterSize(tmp, 1<<20)
+	if err = write(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), archive)
+}
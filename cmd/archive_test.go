@@ -0,0 +1,141 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressDIRManifest(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	// The "docs" folder is a copy with its own sidecar manifest.
+	src := filepath.Join(dir, "docs")
+	files := map[string]string{"a.txt": "file a", "sub/b.txt": "file b", "sub/c.log": "ignored", ManifestName: `{"version": 1}`}
+	for name, data := range files {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range writableFormats() {
+		t.Run(name, func(t *testing.T) {
+			format, err := archiveFormatFor(name, 0, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "docs."+name)
+			err = writeArchiveAtomic(archive, func(w io.Writer) error {
+				return compressDIR(src, w, format, 0, 1, []string{"*.log"}, nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ar, _, err := openArchive(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ar.Close()
+			var names []string
+			var m *manifest
+			for {
+				e, r, err := ar.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, e.name)
+				if isManifestEntry(e.name) {
+					if m, err = readManifest(r); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			// The manifest of the archive is its first entry and replaces the sidecar manifest.
+			want := []string{"docs/" + ManifestName, "docs", "docs/a.txt", "docs/sub", "docs/sub/b.txt"}
+			if !reflect.DeepEqual(trimDirSlash(names), want) {
+				t.Errorf("entries = %q, want %q", names, want)
+			}
+			if m == nil {
+				t.Fatal("the archive has no manifest")
+			}
+			sums := map[string]string{}
+			for _, me := range m.Files {
+				sums[me.Path] = me.SHA256
+			}
+			wantSums := map[string]string{"docs/a.txt": testSHA256("file a"), "docs/sub/b.txt": testSHA256("file b")}
+			if !reflect.DeepEqual(sums, wantSums) {
+				t.Errorf("manifest = %v, want %v", sums, wantSums)
+			}
+
+			st, err := testArchive(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !st.manifest || st.errors != 0 || st.files != 2 {
+				t.Errorf("test = %+v, want the manifest, 2 files and no errors", st)
+			}
+		})
+	}
+}
+
+func TestIsManifestEntry(t *testing.T) {
+	tests := map[string]bool{
+		ManifestName:                  true,
+		"./" + ManifestName:           true,
+		"docs/" + ManifestName:        true,
+		"./docs/" + ManifestName:      true,
+		"docs/sub/" + ManifestName:    false,
+		"docs/" + ManifestName + ".1": false,
+		"docs":                        false,
+	}
+	for name, want := range tests {
+		if got := isManifestEntry(name); got != want {
+			t.Errorf("isManifestEntry(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// trimDirSlash removes the trailing "/" of the folder entry names, the zip and tar archives differ there.
+func trimDirSlash(names []string) []string {
+	trimmed := make([]string, len(names))
+	for i, name := range names {
+		trimmed[i] = filepath.ToSlash(filepath.Clean(name))
+	}
+	return trimmed
+}
+
+// testSHA256 returns the hex encoded SHA-256 hash of the data.
+func testSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
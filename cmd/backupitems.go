@@ -0,0 +1,354 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Backup sections of the 'config.yaml' file, each one holds its own list of backup items.
+const (
+	SecCopyDIRD = "copydir_daily"
+	SecCopyDIRF = "copydir_frequently"
+	SecCopyMD   = "copymd_daily"
+	SecCopyMDF  = "copymd_frequently"
+)
+
+// BackupSections is the list of backup sections in the order they're loaded.
+var BackupSections = []string{SecCopyDIRD, SecCopyDIRF, SecCopyMD, SecCopyMDF}
+
+// backupItemKeys lists the allowed keys of the backup items for each backup section.
+var backupItemKeys = map[string][]string{
+	SecCopyDIRD: {"src", "dst", "run_every", "interval", "run_at", "retention_days"},
+	SecCopyDIRF: {"src", "dst", "run_every", "interval", "retention_days"},
+	SecCopyMD:   {"src", "dst", "run_every", "interval", "run_at", "modified_days"},
+	SecCopyMDF:  {"src", "dst", "run_every", "interval", "modified_days"},
+}
+
+// defaultIntervalOptions is used when the backup section doesn't declare its own "interval_options".
+var defaultIntervalOptions = map[string][]string{
+	SecCopyDIRD: {"days", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"},
+	SecCopyDIRF: {"seconds", "minutes", "hours"},
+	SecCopyMD:   {"days", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"},
+	SecCopyMDF:  {"seconds", "minutes", "hours"},
+}
+
+// runAtFormat matches the "run_at" value in the 24-hour HH:MM format.
+var runAtFormat = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// ConfigError is a single problem found in the 'config.yaml' file.
+type ConfigError struct {
+	File    string
+	Line    int
+	Section string // e.g. backups.copydir_daily or jobs[0]
+	Msg     string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Section, e.Msg)
+}
+
+// ConfigErrors collects all the problems found in the 'config.yaml' file at once.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ConfigFile returns the path of the 'config.yaml' file in use.
+func ConfigFile() string {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f
+	}
+	return "config.yaml"
+}
+
+// readConfigNode reads the 'config.yaml' file as a YAML node tree to keep the line number of each backup item.
+func readConfigNode(file string) (*yaml.Node, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return &doc, nil
+}
+
+// mappingValue returns the value node of the "key" from a YAML mapping node, nil if it's not found.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// intervalOptions returns the "interval_options" of the backup section.
+func intervalOptions(section *yaml.Node, name string) []string {
+	opts := mappingValue(section, "interval_options")
+	if opts == nil || opts.Kind != yaml.SequenceNode {
+		return defaultIntervalOptions[name]
+	}
+
+	var values []string
+	for _, o := range opts.Content {
+		values = append(values, strings.ToLower(strings.TrimSpace(o.Value)))
+	}
+	return values
+}
+
+// backupItem is a single valid backup item, either from the backup_items strings or from the structured jobs.
+type backupItem struct {
+	name     string
+	section  string
+	kv       map[string]string
+	ignore   []string
+	compress bool
+	snapshot bool
+}
+
+// backupItemParser parses the backup items of a single backup section and collects the problems found.
+type backupItemParser struct {
+	file    string
+	section string
+	where   string // Reported as the ConfigError section, e.g. backups.copydir_daily
+	options []string
+	aliases map[string]string // The key names reported instead of the backup item keys, e.g. retention
+	errs    ConfigErrors
+}
+
+// keyName returns the key name as it's written in the 'config.yaml' file.
+func (p *backupItemParser) keyName(key string) string {
+	if alias, ok := p.aliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// errorf records a problem found in the backup item at the "line" of the 'config.yaml' file.
+func (p *backupItemParser) errorf(line int, format string, args ...interface{}) {
+	p.errs = append(p.errs, &ConfigError{File: p.file, Line: line, Section: p.where, Msg: fmt.Sprintf(format, args...)})
+}
+
+// parse splits a single backup item such as "src=C:\a, dst=C:\b, run_every=5, interval=seconds;" into its
+// key and value pairs and validates each of them, ok is false when the backup item has any problems.
+func (p *backupItemParser) parse(line int, item string) (kv map[string]string, ok bool) {
+	nErrs := len(p.errs)
+	kv = make(map[string]string)
+
+	item = strings.TrimSpace(item)
+	if !strings.HasSuffix(item, ";") {
+		p.errorf(line, "backup item must be terminated with ';'")
+	}
+
+	for _, pair := range strings.Split(strings.TrimSuffix(item, ";"), ",") {
+		pair = strings.TrimSpace(pair)
+		kvp := strings.SplitN(pair, "=", 2)
+		if len(kvp) != 2 {
+			p.errorf(line, "invalid field %q, it must be in key=value format", pair)
+			continue
+		}
+
+		key, value := strings.TrimSpace(kvp[0]), strings.TrimSpace(kvp[1])
+		_, dup := kv[key]
+		switch {
+		case !p.allowedKey(key):
+			p.errorf(line, "unknown key %q, allowed keys are: %s", key, strings.Join(backupItemKeys[p.section], ", "))
+		case dup:
+			p.errorf(line, "duplicate key %q", key)
+		case value == "":
+			p.errorf(line, "missing value for the key %q", key)
+		default:
+			kv[key] = value
+		}
+	}
+
+	p.validate(line, kv)
+	return kv, len(p.errs) == nErrs
+}
+
+// validate checks the key and value pairs of a backup item against its backup section's rules.
+func (p *backupItemParser) validate(line int, kv map[string]string) {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !p.allowedKey(key) {
+			p.errorf(line, "%s is not supported by the %s backup items", p.keyName(key), p.section)
+		}
+	}
+	for _, key := range backupItemKeys[p.section] {
+		if key == "retention_days" || key == "modified_days" {
+			continue // Optional keys
+		}
+		if _, found := kv[key]; !found {
+			p.errorf(line, "missing required key %q", p.keyName(key))
+		}
+	}
+
+	if v, found := kv["run_every"]; found {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			p.errorf(line, "run_every=%s must be a positive number", v)
+		}
+	}
+	if v, found := kv["interval"]; found && !p.allowedInterval(v) {
+		p.errorf(line, "interval=%s is not one of the interval_options: %s", v, strings.Join(p.options, ", "))
+	}
+	if v, found := kv["run_at"]; found && !runAtFormat.MatchString(v) {
+		p.errorf(line, "run_at=%s must be in 24-hour HH:MM format, e.g. 23:30", v)
+	}
+	for _, key := range []string{"retention_days", "modified_days"} {
+		if v, found := kv[key]; found {
+			if n, err := strconv.Atoi(v); err != nil || n >= 0 {
+				p.errorf(line, "%s=%s must be a negative number of days, e.g. -30", p.keyName(key), v)
+			}
+		}
+	}
+}
+
+// allowedKey checks if the key is allowed in the backup items of the section.
+func (p *backupItemParser) allowedKey(key string) bool {
+	return inList(backupItemKeys[p.section], key)
+}
+
+// allowedInterval checks if the interval is one of the section's "interval_options".
+func (p *backupItemParser) allowedInterval(interval string) bool {
+	for _, o := range p.options {
+		if o == strings.ToLower(interval) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadBackupItems parses and validates all the automated backup items and the structured jobs from the
+// 'config.yaml' file into the MapCopyDIRD, MapCopyDIRF, MapCopyMD and MapCopyMDF collections, all the
+// problems found are returned at once as ConfigErrors.
+func LoadBackupItems() error {
+	MapCopyDIRD = make(map[int]STCopyDIRD)
+	MapCopyDIRF = make(map[int]STCopyDIRF)
+	MapCopyMD = make(map[int]STCopyMD)
+	MapCopyMDF = make(map[int]STCopyMDF)
+	BKSD, BKSF, BKMD, BKMDF = nil, nil, nil, nil
+
+	file := ConfigFile()
+	doc, err := readConfigNode(file)
+	if err != nil {
+		return err
+	}
+
+	var errs ConfigErrors
+	backups := mappingValue(doc, "backups")
+	for _, name := range BackupSections {
+		section := mappingValue(backups, name)
+		items := mappingValue(section, "backup_items")
+		if items == nil || items.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		p := &backupItemParser{file: file, section: name, where: "backups." + name, options: intervalOptions(section, name)}
+		for n, node := range items.Content {
+			if isEmptyNode(node) {
+				continue // Empty backup item, e.g. "- "
+			}
+			if node.Kind != yaml.ScalarNode {
+				p.errorf(node.Line, "backup item must be a string, e.g. src=C:\\a, dst=C:\\b, run_every=1, interval=days; or use the structured jobs instead")
+				continue
+			}
+
+			kv, ok := p.parse(node.Line, node.Value)
+			if !ok {
+				continue
+			}
+			snapshot := viper.GetBool("default.snapshot")
+			if kv["retention_days"] != "" && !snapshot {
+				// Without the snapshots, dst is the live copy and the prune would delete its files.
+				p.errorf(node.Line, "retention_days is only supported with the default.snapshot: true setting")
+				continue
+			}
+			addBackupItem(backupItem{name: fmt.Sprintf("%s_%d", name, n+1), section: name, kv: kv, snapshot: snapshot})
+		}
+		errs = append(errs, p.errs...)
+	}
+
+	items, jobErrs := parseJobs(file, doc)
+	errs = append(errs, jobErrs...)
+	for _, item := range items {
+		addBackupItem(item)
+	}
+
+	IsBKItemsFound = len(BKSD)+len(BKSF)+len(BKMD)+len(BKMDF) > 0
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// isEmptyNode checks if the YAML node is a null or blank value.
+func isEmptyNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && (node.Tag == "!!null" || strings.TrimSpace(node.Value) == "")
+}
+
+// addBackupItem stores a valid backup item into its backup section's collection.
+func addBackupItem(item backupItem) {
+	kv := item.kv
+	runEvery, _ := strconv.Atoi(kv["run_every"])
+	retentionDays, _ := strconv.Atoi(kv["retention_days"])
+	interval := strings.ToLower(kv["interval"])
+
+	switch item.section {
+	case SecCopyDIRD:
+		MapCopyDIRD[len(BKSD)] = STCopyDIRD{name: item.name, src: kv["src"], dst: kv["dst"], runEvery: runEvery, interval: interval,
+			runAt: kv["run_at"], intervalType: item.section, retentionDays: retentionDays, ignore: item.ignore, compress: item.compress, snapshot: item.snapshot}
+		BKSD = append(BKSD, item.name)
+	case SecCopyDIRF:
+		MapCopyDIRF[len(BKSF)] = STCopyDIRF{name: item.name, src: kv["src"], dst: kv["dst"], runEvery: runEvery, interval: interval,
+			intervalType: item.section, retentionDays: retentionDays, ignore: item.ignore, compress: item.compress, snapshot: item.snapshot}
+		BKSF = append(BKSF, item.name)
+	case SecCopyMD:
+		MapCopyMD[len(BKMD)] = STCopyMD{name: item.name, src: kv["src"], dst: kv["dst"], runEvery: runEvery, interval: interval,
+			runAt: kv["run_at"], intervalType: item.section, copyModNumDays: kv["modified_days"], ignore: item.ignore}
+		BKMD = append(BKMD, item.name)
+	case SecCopyMDF:
+		MapCopyMDF[len(BKMDF)] = STCopyMDF{name: item.name, src: kv["src"], dst: kv["dst"], runEvery: runEvery, interval: interval,
+			intervalType: item.section, copyModNumDays: kv["modified_days"], ignore: item.ignore}
+		BKMDF = append(BKMDF, item.name)
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBackupItemParserParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		options []string // The section's interval_options, the default ones when it's nil
+		item    string
+		kv      map[string]string
+		errs    []string
+	}{
+		{
+			name:    "frequent item",
+			section: SecCopyDIRF,
+			item:    `src=C:\a, dst=C:\b, run_every=5, interval=seconds;`,
+			kv:      map[string]string{"src": `C:\a`, "dst": `C:\b`, "run_every": "5", "interval": "seconds"},
+		},
+		{
+			name:    "daily item with retention",
+			section: SecCopyDIRD,
+			item:    ` src = /a , dst=/b,run_every=1, interval=Monday, run_at=23:30, retention_days=-30; `,
+			kv:      map[string]string{"src": "/a", "dst": "/b", "run_every": "1", "interval": "Monday", "run_at": "23:30", "retention_days": "-30"},
+		},
+		{
+			name:    "copymd item with modified days",
+			section: SecCopyMDF,
+			item:    `src=a, dst=b, run_every=30, interval=minutes, modified_days=-7;`,
+			kv:      map[string]string{"src": "a", "dst": "b", "run_every": "30", "interval": "minutes", "modified_days": "-7"},
+		},
+		{
+			name:    "value with equal sign",
+			section: SecCopyDIRF,
+			item:    `src=/a=b, dst=/c, run_every=1, interval=hours;`,
+			kv:      map[string]string{"src": "/a=b", "dst": "/c", "run_every": "1", "interval": "hours"},
+		},
+		{
+			name:    "missing terminator",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=5, interval=seconds`,
+			errs:    []string{`backup item must be terminated with ';'`},
+		},
+		{
+			name:    "field without value separator",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=5, interval=seconds, oops;`,
+			errs:    []string{`invalid field "oops", it must be in key=value format`},
+		},
+		{
+			name:    "unknown key",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=5, interval=seconds, run_at=10:00;`,
+			errs:    []string{`unknown key "run_at", allowed keys are: src, dst, run_every, interval, retention_days`},
+		},
+		{
+			name:    "copydir item with modified days",
+			section: SecCopyDIRD,
+			item:    `src=a, dst=b, run_every=1, interval=days, run_at=10:00, modified_days=-1;`,
+			errs:    []string{`unknown key "modified_days", allowed keys are: src, dst, run_every, interval, run_at, retention_days`},
+		},
+		{
+			name:    "duplicate key",
+			section: SecCopyDIRF,
+			item:    `src=a, src=b, dst=c, run_every=5, interval=seconds;`,
+			errs:    []string{`duplicate key "src"`},
+		},
+		{
+			name:    "missing value",
+			section: SecCopyDIRF,
+			item:    `src=, dst=b, run_every=5, interval=seconds;`,
+			errs:    []string{`missing value for the key "src"`, `missing required key "src"`},
+		},
+		{
+			name:    "missing required keys",
+			section: SecCopyMD,
+			item:    `src=a, run_every=1;`,
+			errs:    []string{`missing required key "dst"`, `missing required key "interval"`, `missing required key "run_at"`},
+		},
+		{
+			name:    "zero run_every",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=0, interval=seconds;`,
+			errs:    []string{`run_every=0 must be a positive number`},
+		},
+		{
+			name:    "non-numeric run_every",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=five, interval=seconds;`,
+			errs:    []string{`run_every=five must be a positive number`},
+		},
+		{
+			name:    "daily interval in a frequent section",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=1, interval=days;`,
+			errs:    []string{`interval=days is not one of the interval_options: seconds, minutes, hours`},
+		},
+		{
+			name:    "interval not in the section's options",
+			section: SecCopyDIRD,
+			options: []string{"days", "sunday"},
+			item:    `src=a, dst=b, run_every=1, interval=monday, run_at=10:00;`,
+			errs:    []string{`interval=monday is not one of the interval_options: days, sunday`},
+		},
+		{
+			name:    "run_at past midnight",
+			section: SecCopyDIRD,
+			item:    `src=a, dst=b, run_every=1, interval=days, run_at=24:00;`,
+			errs:    []string{`run_at=24:00 must be in 24-hour HH:MM format, e.g. 23:30`},
+		},
+		{
+			name:    "run_at without leading zero",
+			section: SecCopyMD,
+			item:    `src=a, dst=b, run_every=1, interval=days, run_at=9:30;`,
+			errs:    []string{`run_at=9:30 must be in 24-hour HH:MM format, e.g. 23:30`},
+		},
+		{
+			name:    "positive retention",
+			section: SecCopyDIRD,
+			item:    `src=a, dst=b, run_every=1, interval=days, run_at=10:00, retention_days=30;`,
+			errs:    []string{`retention_days=30 must be a negative number of days, e.g. -30`},
+		},
+		{
+			name:    "zero retention",
+			section: SecCopyDIRF,
+			item:    `src=a, dst=b, run_every=1, interval=hours, retention_days=0;`,
+			errs:    []string{`retention_days=0 must be a negative number of days, e.g. -30`},
+		},
+		{
+			name:    "non-numeric modified days",
+			section: SecCopyMDF,
+			item:    `src=a, dst=b, run_every=1, interval=hours, modified_days=-1d;`,
+			errs:    []string{`modified_days=-1d must be a negative number of days, e.g. -30`},
+		},
+		{
+			name:    "all the problems at once",
+			section: SecCopyDIRD,
+			item:    `src=a, run_every=0, interval=weekly, run_at=noon, retention_days=7`,
+			errs: []string{
+				`backup item must be terminated with ';'`,
+				`missing required key "dst"`,
+				`run_every=0 must be a positive number`,
+				`interval=weekly is not one of the interval_options: days, monday, tuesday, wednesday, thursday, friday, saturday, sunday`,
+				`run_at=noon must be in 24-hour HH:MM format, e.g. 23:30`,
+				`retention_days=7 must be a negative number of days, e.g. -30`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			if options == nil {
+				options = defaultIntervalOptions[tt.section]
+			}
+			p := &backupItemParser{file: "config.yaml", section: tt.section, where: "backups." + tt.section, options: options}
+			kv, ok := p.parse(7, tt.item)
+
+			if ok != (len(tt.errs) == 0) {
+				t.Errorf("ok = %v with the errors: %v", ok, p.errs)
+			}
+			if tt.kv != nil && !reflect.DeepEqual(kv, tt.kv) {
+				t.Errorf("kv = %v, want %v", kv, tt.kv)
+			}
+			checkConfigErrors(t, p.errs, 7, "backups."+tt.section, tt.errs)
+		})
+	}
+}
+
+func TestBackupItemParserValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		kv      map[string]string
+		errs    []string
+	}{
+		{
+			name:    "daily job",
+			section: SecCopyDIRD,
+			kv:      map[string]string{"src": "a", "dst": "b", "run_every": "1", "interval": "days", "run_at": "23:30", "retention_days": "-30"},
+		},
+		{
+			name:    "retention is reported by its job key",
+			section: SecCopyDIRF,
+			kv:      map[string]string{"src": "a", "dst": "b", "run_every": "1", "interval": "hours", "retention_days": "30"},
+			errs:    []string{`retention=30 must be a negative number of days, e.g. -30`},
+		},
+		{
+			name:    "run_at of a frequent job",
+			section: SecCopyMDF,
+			kv:      map[string]string{"src": "a", "dst": "b", "run_every": "1", "interval": "hours", "run_at": "10:00"},
+			errs:    []string{`run_at is not supported by the copymd_frequently backup items`},
+		},
+		{
+			name:    "missing schedule",
+			section: SecCopyMD,
+			kv:      map[string]string{"src": "a", "dst": "b"},
+			errs:    []string{`missing required key "run_every"`, `missing required key "interval"`, `missing required key "run_at"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &backupItemParser{file: "config.yaml", section: tt.section, where: "jobs[0]", options: defaultIntervalOptions[tt.section],
+				aliases: map[string]string{"retention_days": "retention"}}
+			p.validate(12, tt.kv)
+			checkConfigErrors(t, p.errs, 12, "jobs[0]", tt.errs)
+		})
+	}
+}
+
+func TestLoadBackupItems(t *testing.T) {
+	config := `backups:
+  copydir_daily:
+    backup_items:
+      - src=/a, dst=/b, run_every=1, interval=days, run_at=11:45;
+      - src=/a, dst=/c, run_every=1, interval=days, run_at=11:45, retention_days=-30;
+      - 
+  copydir_frequently:
+    interval_options: [minutes]
+    backup_items:
+      - src=/a, dst=/d, run_every=5, interval=seconds;
+      - src=/a, dst=/e, run_every=5, interval=minutes;
+  copymd_daily:
+    backup_items:
+      - {src: /a, dst: /f}
+      - src=/a, dst=/g, run_every=1, interval=friday, run_at=7:00, modified_days=1;
+  copymd_frequently:
+    backup_items:
+      - src=/a, dst=/h, run_every=1, interval=hours, modified_days=-2;
+`
+	tests := []struct {
+		name     string
+		snapshot bool
+		items    []string // The loaded backup items of each section
+		errs     []string
+	}{
+		{
+			name:  "without snapshots",
+			items: []string{"copydir_daily_1", "copydir_frequently_2", "copymd_frequently_1"},
+			errs: []string{
+				`config.yaml:5: backups.copydir_daily: retention_days is only supported with the default.snapshot: true setting`,
+				`config.yaml:10: backups.copydir_frequently: interval=seconds is not one of the interval_options: minutes`,
+				`config.yaml:14: backups.copymd_daily: backup item must be a string, e.g. src=C:\a, dst=C:\b, run_every=1, interval=days; or use the structured jobs instead`,
+				`config.yaml:15: backups.copymd_daily: run_at=7:00 must be in 24-hour HH:MM format, e.g. 23:30`,
+				`config.yaml:15: backups.copymd_daily: modified_days=1 must be a negative number of days, e.g. -30`,
+			},
+		},
+		{
+			name:     "with snapshots",
+			snapshot: true,
+			items:    []string{"copydir_daily_1", "copydir_daily_2", "copydir_frequently_2", "copymd_frequently_1"},
+			errs: []string{
+				`config.yaml:10: backups.copydir_frequently: interval=seconds is not one of the interval_options: minutes`,
+				`config.yaml:14: backups.copymd_daily: backup item must be a string, e.g. src=C:\a, dst=C:\b, run_every=1, interval=days; or use the structured jobs instead`,
+				`config.yaml:15: backups.copymd_daily: run_at=7:00 must be in 24-hour HH:MM format, e.g. 23:30`,
+				`config.yaml:15: backups.copymd_daily: modified_days=1 must be a negative number of days, e.g. -30`,
+			},
+		},
+	}
+	if err := ioutil.WriteFile("config.yaml", []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("config.yaml")
+	defer viper.Set("default.snapshot", false)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("default.snapshot", tt.snapshot)
+			err := LoadBackupItems()
+			errs, ok := err.(ConfigErrors)
+			if !ok {
+				t.Fatalf("error = %v, want ConfigErrors", err)
+			}
+			if got := strings.Split(errs.Error(), "\n"); !reflect.DeepEqual(got, tt.errs) {
+				t.Errorf("errors =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.errs, "\n"))
+			}
+
+			items := append(append(append(append([]string{}, BKSD...), BKSF...), BKMD...), BKMDF...)
+			if !reflect.DeepEqual(items, tt.items) {
+				t.Errorf("backup items = %v, want %v", items, tt.items)
+			}
+			if MapCopyDIRD[0].src != "/a" || MapCopyDIRD[0].dst != "/b" || MapCopyDIRD[0].runAt != "11:45" {
+				t.Errorf("copydir_daily_1 = %+v", MapCopyDIRD[0])
+			}
+			if tt.snapshot && MapCopyDIRD[1].retentionDays != -30 {
+				t.Errorf("copydir_daily_2 retention days = %d, want -30", MapCopyDIRD[1].retentionDays)
+			}
+		})
+	}
+}
+
+// checkConfigErrors compares the messages of the ConfigErrors, all of them must be of the line and section.
+func checkConfigErrors(t *testing.T, errs ConfigErrors, line int, section string, want []string) {
+	t.Helper()
+	var msgs []string
+	for _, e := range errs {
+		msgs = append(msgs, e.Msg)
+		if e.File != "config.yaml" || e.Line != line || e.Section != section {
+			t.Errorf("error %q is at %s:%d %s, want config.yaml:%d %s", e.Msg, e.File, e.Line, e.Section, line, section)
+		}
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("errors =\n%s\nwant\n%s", strings.Join(msgs, "\n"), strings.Join(want, "\n"))
+	}
+}
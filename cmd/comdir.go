@@ -16,7 +16,6 @@ limitations under the License.
 package cmd
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -35,7 +34,21 @@ var comdirCmd = &cobra.Command{
 	Use:   "comdir",
 	Short: "Compress the entire directory or a folder",
 	Long: `comdir command will compress the specified directory or a folder including the sub-folders and its contents as well
-using .tar.gz compression format.
+using .tar.gz compression format, or the --format flag or the default.comdir_format setting: tar.gz, tar.zst,
+tar.xz, tar.lz4 or zip. The --level flag sets the compression level of the format.
+
+The tar.gz and tar.zst archives can be compressed on many CPU cores with the --threads flag or the
+default.compression_threads setting, they're still readable by the standard tar, gzip and zstd tools.
+
+With the --snapshot flag or the default.snapshot setting on, each archive is named with its timestamp in the
+job folder "dst/<job>/<folder_name>_<YYYY-MM-DD_HHMMSS>.tar.gz" with a "latest" symlink pointing at the newest one.
+
+The --min-size, --max-size, --newer-than, --older-than and --only-ext flags only archive the files of that
+size, modification date or extension, on top of the ignore list.
+
+The first entry of the archive is the "<folder_name>/` + ManifestName + `" manifest with the size, modification
+time and SHA-256 hash of every archived file, it's checked by the test command and left out by the dcdir and
+extract commands.
 
 Example of a valid directory path in Windows:
 "C:\source_folder_to_compress" "D:\backup_destination"
@@ -52,45 +65,114 @@ Or in Linux:
 		src := filepath.FromSlash(args[0])
 		dst := filepath.FromSlash(args[1])
 
-		msg := `Start compressing the directory or a folder:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-
-		// Compose the zip filename
-		fnWOext := kopy.FileNameWOExt(filepath.Base(args[0])) // Returns a filename without an extension.
-		zipDir := fnWOext + kopy.ComFileFormat
+		resolveSnapshotFlags(cmd, &comdirOpts, src)
 
-		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
-		// depends on the user's OS using the filepath.FromSlash organic Go's library.
-		zipDest := filepath.FromSlash(path.Join(args[1], zipDir))
-
-		// Start compressing the entire directory or a folder using the tar + gzip
-		var buf bytes.Buffer
-		if err := kopy.CompressDIR(src, &buf, IgnoreFT); err != nil {
-			fmt.Println(err)
-			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			return
-		}
+		// Errors are already reported to the user's console and the logs.
+		runComDIR(src, dst, comdirOpts)
+	},
+}
 
-		// write the .tar.gzip
-		os.MkdirAll(dst, os.ModePerm) // Create the root folder first
-		fileToWrite, err := os.OpenFile(zipDest, os.O_CREATE|os.O_RDWR, os.FileMode(600))
+// comdirOpts is the --snapshot, --job, --format, --level, --threads and filter flags of the comdir command.
+var comdirOpts backupOptions
+
+// runComDIR compresses the entire directory or a folder, it's shared by the comdir command and the
+// scheduled copydir jobs with "compress: true".
+func runComDIR(src, dst string, opts backupOptions) error {
+	// Get the list of ignored file types.
+	IgnoreFT = IgnoreList(opts.ignore)
+
+	format, level, threads, err := resolveFormat(opts.compress, "default.comdir_format")
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+	filter, err := parseFilter(opts.filters)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	msg := `Start compressing the directory or a folder:`
+	fmt.Println(msg, src)
+	Sugar.Infow(msg, "src", src, "dst", dst, "format", format.name, "level", level, "threads", threads, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	// Compose the zip filename
+	fnWOext := kopy.FileNameWOExt(filepath.Base(src)) // Returns a filename without an extension.
+	zipDir := archiveName(fnWOext, format)
+
+	// Each snapshot is named with its timestamp in the job folder, e.g. dst/<job>/<name>_<YYYY-MM-DD_HHMMSS>.tar.gz
+	if opts.snapshot {
+		dst = filepath.Join(dst, opts.job)
+		stamp := snapshotTime(dst, func(stamp string) string { return archiveName(fnWOext+"_"+stamp, format) })
+		zipDir = archiveName(fnWOext+"_"+stamp, format)
+	}
+
+	// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
+	// depends on the user's OS using the filepath.FromSlash organic Go's library.
+	zipDest := filepath.FromSlash(path.Join(dst, zipDir))
+
+	if DryRun {
+		files, bytes, err := planCompressDIR(src, IgnoreFT, filter)
 		if err != nil {
+			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			panic(err)
+			return err
 		}
-		if _, err := io.Copy(fileToWrite, &buf); err != nil {
+		dryRunAction("write archive", zipDest, -1)
+		msg = dryRunMsg(`Done compressing the directory or a folder:`)
+		fmt.Println(msg, src, " Number of Files: ", files, " Filtered: ", filter.filteredCount(), " Total Bytes: ", bytes)
+		Sugar.Infow(msg, "dst", zipDest, "files", files, "files_filtered", filter.filteredCount(), "total_bytes", bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return nil
+	}
+
+	// Stream the archive straight into a temporary file of the dst folder, so the memory use doesn't
+	// depend on the folder size, and only a complete archive gets the zipDest name.
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+	err = writeArchiveAtomic(zipDest, func(w io.Writer) error { return compressDIR(src, w, format, level, threads, IgnoreFT, filter) })
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	msg = `Done compressing the directory or a folder:`
+	fmt.Println(msg, src, " Filtered: ", filter.filteredCount())
+	Sugar.Infow(msg, "dst", zipDest, "files_filtered", filter.filteredCount(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	if opts.snapshot {
+		// The archive is complete, only then the "latest" symlink points at it.
+		if err := updateLatest(zipDest); err != nil {
+			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			panic(err)
 		}
-		defer fileToWrite.Close()
+	}
+	return nil
+}
 
-		msg = `Done compressing the directory or a folder:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "dst", zipDest, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-	},
+// planCompressDIR reports every file that compressDIR would archive from the src folder, it returns the
+// number of files and their total size.
+func planCompressDIR(src string, ignore []string, filter *fileFilter) (int, int64, error) {
+	files, bytes := 0, int64(0)
+	err := walkArchiveTree(src, ignore, filter, func(file, name string, fi os.FileInfo) error {
+		if fi.Mode().IsRegular() {
+			files++
+			bytes += fi.Size()
+			dryRunAction("archive file", name, fi.Size())
+		}
+		return nil
+	})
+	return files, bytes, err
 }
 
 func init() {
 	rootCmd.AddCommand(comdirCmd)
+	addSnapshotFlags(comdirCmd, &comdirOpts)
+	addFormatFlags(comdirCmd, &comdirOpts.compress, "default.comdir_format")
+	addFilterFlags(comdirCmd, &comdirOpts.filters)
 }
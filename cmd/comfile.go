@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -32,7 +33,10 @@ import (
 var comfileCmd = &cobra.Command{
 	Use:   "comfile",
 	Short: "Compress any single file",
-	Long: `comfile command will compress any single file using .zip compression format.
+	Long: `comfile command will compress any single file using .zip compression format, or the --format flag or
+the default.comfile_format setting: zip, tar.gz, tar.zst, tar.xz or tar.lz4. The --level flag sets the
+compression level of the format, and the --threads flag compresses the tar.gz and tar.zst archives
+on many CPU cores.
 
 Example of a valid directory path in Windows:
 "C:\source_folder\filename.txt" "D:\backup_destination"
@@ -48,24 +52,43 @@ Or in Linux:
 		src := filepath.FromSlash(args[0])
 		dst := filepath.FromSlash(args[1])
 
+		format, level, threads, err := resolveFormat(comfileOpts, "default.comfile_format")
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return
+		}
+
 		// Start the process.
 		msg := `Start compressing the file:`
 		fmt.Println(msg, src)
-		Sugar.Errorw(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		Sugar.Infow(msg, "src", src, "dst", dst, "format", format.name, "level", level, "threads", threads, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 
 		// Compose the zip filename
 		fnWOext := kopy.FileNameWOExt(filepath.Base(args[0])) // Returns a filename without an extension.
-		zipFileName := fnWOext + ".zip"
+		zipFileName := archiveName(fnWOext, format)
 
 		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		zipDest := filepath.FromSlash(path.Join(args[1], zipFileName))
 
-		// List of Files to compressed.
-		files := []string{src}
+		if DryRun {
+			info, err := os.Stat(src)
+			if err != nil {
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				return
+			}
+			dryRunAction("write archive", zipDest, -1)
+			msg = dryRunMsg(`Done compressing the file:`)
+			fmt.Println(msg, src, " Total Bytes: ", info.Size())
+			Sugar.Infow(msg, "src", src, "dst", zipDest, "total_bytes", info.Size(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return
+		}
 
 		os.MkdirAll(dst, os.ModePerm) // Create the root folder first
-		if err := kopy.ComFiles(zipDest, files, Sugar); err != nil {
+		err = writeArchiveAtomic(zipDest, func(w io.Writer) error { return compressFile(src, w, format, level, threads) })
+		if err != nil {
 			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 			return
@@ -77,6 +100,43 @@ Or in Linux:
 	},
 }
 
+// comfileOpts is the --format, --level and --threads flags of the comfile command.
+var comfileOpts compressOptions
+
+// compressFile writes the single src file into an archive of the format, the entry is named after the file.
+// The manifest with its SHA-256 hash is the first entry.
+func compressFile(src string, w io.Writer, format *archiveFormat, level, threads int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	sum, err := fileSHA256(src)
+	if err != nil {
+		return err
+	}
+	m := newManifest()
+	m.add(filepath.Base(src), fi, sum)
+
+	aw, err := newArchiveWriter(w, format, level, threads)
+	if err != nil {
+		return err
+	}
+	if err := m.addToArchive(aw, ManifestName); err != nil {
+		return err
+	}
+	if err := aw.add(filepath.Base(src), fi, "", f); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
 func init() {
 	rootCmd.AddCommand(comfileCmd)
+	addFormatFlags(comfileCmd, &comfileOpts, "default.comfile_format")
 }
@@ -0,0 +1,175 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the 'config.yaml' file",
+	Long:  `config command groups the sub-commands to check and maintain the 'config.yaml' configuration file.`,
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check all the backup items of the 'config.yaml' file",
+	Long: `config validate command parses every backup_items entry of the copydir_daily, copydir_frequently, copymd_daily
+and copymd_frequently sections of the 'config.yaml' file and reports all the problems found at once
+with their line numbers, such as:
+
+	- unknown or duplicate keys
+	- missing src, dst, run_every, interval or run_at keys
+	- an interval that's not one of the section's interval_options
+	- a run_at value that's not in HH:MM format
+	- a retention_days or modified_days value that's not a negative number of days
+
+It exits with a non-zero status when any problems were found.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd)
+		file := ConfigFile()
+		err := LoadBackupItems()
+		if errs, ok := err.(ConfigErrors); ok {
+			for _, e := range errs {
+				fmt.Println(e)
+				Sugar.Errorw("invalid backup item", "file", e.File, "line", e.Line, "section", e.Section, "err", e.Msg, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			}
+			fmt.Println("Number of problems found:", len(errs))
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg := `The config file is valid:`
+		fmt.Println(msg, file, " Number of Backup Items: ", len(BKSD)+len(BKSF)+len(BKMD)+len(BKMDF))
+		Sugar.Infow(msg, "file", file, "backup_items", len(BKSD)+len(BKSF)+len(BKMD)+len(BKMDF), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// configMigrateCmd represents the config migrate command
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Convert the backup_items strings into the structured jobs",
+	Long: `config migrate command rewrites every backup_items string of the copydir_daily, copydir_frequently, copymd_daily
+and copymd_frequently sections of the 'config.yaml' file into the structured "jobs" list, for example:
+
+backups:
+	copydir_daily:
+		backup_items:
+			- src=C:\a, dst=C:\b, run_every=1, interval=days, run_at=11:45, retention_days=-30;
+
+Becomes:
+
+jobs:
+	- name: copydir_daily_1
+		type: copydir
+		src: C:\a
+		dst: C:\b
+		schedule:
+			run_every: 1
+			interval: days
+			run_at: "11:45"
+		retention: -30
+
+The comments of each backup item are carried over to its new job, and a copy of the original file is kept
+with the ".bak" extension. All the backup items must be valid before they can be migrated.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd)
+		file := ConfigFile()
+		if err := LoadBackupItems(); err != nil {
+			fmt.Println(err)
+			fmt.Println("Please fix the problems above before migrating the config file, nothing has been changed.")
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		doc, err := readConfigNode(file)
+		if err == nil && doc.Kind == 0 {
+			err = fmt.Errorf("%s: the config file is empty", file)
+		}
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		nMigrated, err := migrateBackupItems(doc)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		if nMigrated == 0 {
+			fmt.Println("There are no backup_items strings to migrate:", file)
+			return
+		}
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		enc.Close()
+
+		// Keep the original config file before it's replaced.
+		if err := ioutil.WriteFile(file+".bak", data, 0644); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg := `Successfully migrated the backup items into the structured jobs:`
+		fmt.Println(msg, file, " Number of Jobs Migrated: ", nMigrated, " Original File: ", file+".bak")
+		Sugar.Infow(msg, "file", file, "migrated_jobs", nMigrated, "backup_file", file+".bak", "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	rootCmd.AddCommand(configCmd)
+}
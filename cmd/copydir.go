@@ -17,13 +17,14 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/itrepablik/itrlog"
-	"github.com/itrepablik/kopy"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // copydirCmd represents the copydir command
@@ -31,7 +32,36 @@ var copydirCmd = &cobra.Command{
 	Use:   "copydir",
 	Short: "Copy the entire folder or a directory without a compression",
 	Long: `copydir command is to copy the entire directory or folder including its sub-folders and sub-directories contents.
-Take note that, it will replace any existing files and its contents to the destination directory or a folder.
+Take note that, it will replace any existing files and its contents to the destination directory or a folder,
+unless the --snapshot flag or the default.snapshot setting is on, then each run writes into its own
+timestamped folder "dst/<job>/<YYYY-MM-DD_HHMMSS>" with a "latest" symlink pointing at the newest one.
+
+The files that have the same size and modification time as in the previous snapshot are hard-linked
+instead of copied, so every snapshot looks complete but only the changed files use more disk space.
+
+With the --incremental flag or the default.incremental setting, the files that have the same size and
+modification time in the destination folder are skipped instead of copied again.
+Use the --checksum flag to compare their SHA-256 hash as well.
+
+With the --mirror flag, the files and folders of the destination folder that aren't in the source folder
+are deleted, or moved into a timestamped folder of the --quarantine folder. The ignored files are kept.
+The --delete-limit flag aborts the run before anything is removed when it would remove more than N
+files, e.g. 100, or N percent of the files in the destination folder, e.g. 10%.
+Nothing is removed when the source folder can't be read, or when it's empty and the --delete-limit
+flag isn't set.
+
+The --min-size, --max-size, --newer-than, --older-than and --only-ext flags only copy the files of
+that size, modification date or extension, on top of the ignore list, e.g. --max-size 4GB to leave out
+the huge VM images.
+
+With the global --dry-run flag, every file that would be copied, linked, skipped or removed is printed
+and logged, but nothing is written.
+
+With the --verify flag, every copied file is read back and compared with the SHA-256 hash of the source
+computed while it was copied, a file that doesn't match is copied once again, then the run fails.
+
+Once the files are copied, the "` + ManifestName + `" manifest with the size, modification time and SHA-256
+hash of every file is written into the destination folder, to check the copy against later.
 
 It must have a valid and absolute path for the source and its destination folder or directory.
 The Source and Destination paths should contains the "" space "" characters with one space in between to separate them.
@@ -46,35 +76,127 @@ Or in Linux:
 "/root/src" "/root/dst"`,
 	Args: cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		// Get the list of ignored file types.
-		IgnoreFileTypes = viper.Get("ignore.file_type_or_folder_name")
-		IGFT := fmt.Sprint(IgnoreFileTypes)
-		IgnoreFT = strings.Split(IGFT, ",")
-		
 		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		src := filepath.FromSlash(args[0])
 		dst := filepath.FromSlash(args[1])
 
-		msg := `Starts copying the entire directory or a folder: `
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		resolveSnapshotFlags(cmd, &copydirOpts, src)
+		if !cmd.Flags().Changed("incremental") {
+			copydirOpts.incremental = viper.GetBool("default.incremental")
+		}
 
-		// Starts copying the entire directory or a folder.
-		filesCopied, foldersCopied, err := kopy.CopyDir(src, dst, IsLogCopiedFile, IgnoreFT, Sugar)
-		if err != nil {
-			fmt.Println(err)
-			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		if copydirOpts.mirror && copydirOpts.snapshot {
+			fmt.Println("--mirror can't be used with the snapshots, every snapshot is a new folder.")
 			return
 		}
 
-		// Give some info back to the user's console and the logs as well.
-		msg = `Successfully copied the entire directory or a folder: `
-		fmt.Println(msg, src, ", Number of Folders Copied: ", filesCopied, " Number of Files Copied: ", foldersCopied)
-		Sugar.Infow(msg, "src", src, "dst", dst, "folder_copied", filesCopied, "files_copied", foldersCopied, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		// Errors are already reported to the user's console and the logs.
+		runCopyDIR(src, dst, copydirOpts)
 	},
 }
 
+// copydirOpts is the --snapshot, --job, --checksum, --verify, --incremental and --mirror flags of the copydir command.
+var copydirOpts backupOptions
+
+// runCopyDIR copies the entire directory or a folder, it's shared by the copydir command and the
+// scheduled copydir_daily and copydir_frequently backup items.
+func runCopyDIR(src, dst string, opts backupOptions) (err error) {
+	// Get the list of ignored file types.
+	IgnoreFT = IgnoreList(opts.ignore)
+
+	// Each snapshot has its own timestamped folder in the job folder, the files that haven't changed
+	// since the previous snapshot are hard-linked from it.
+	linkDest := ""
+	if opts.snapshot {
+		linkDest = latestSnapshot(filepath.Join(dst, opts.job))
+		var snapDir string
+		if snapDir, err = newSnapshotDir(dst, opts.job); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return err
+		}
+		dst = snapDir
+
+		// A failed snapshot is removed, otherwise it'd be the newest snapshot to link from and to keep.
+		if !DryRun {
+			defer func() {
+				if err != nil {
+					os.RemoveAll(snapDir)
+				}
+			}()
+		}
+	}
+
+	msg := `Starts copying the entire directory or a folder: `
+	fmt.Println(msg, src)
+	Sugar.Infow(msg, "src", src, "link_dest", linkDest, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	filter, err := parseFilter(opts.filters)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	// The files that were deleted from the source are removed first, a file may have become a folder.
+	removed := 0
+	if opts.mirror {
+		if removed, err = runMirror(src, dst, opts, IgnoreFT); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return err
+		}
+	}
+
+	// Starts copying the entire directory or a folder.
+	stats, err := copyTree(src, dst, linkDest, opts, IgnoreFT, filter)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	// Give some info back to the user's console and the logs as well.
+	msg = dryRunMsg(`Successfully copied the entire directory or a folder: `)
+	fmt.Println(msg, src, ", Number of Folders Copied: ", stats.folders, " Number of Files Copied: ", stats.files, " Skipped: ", stats.skipped,
+		" Filtered: ", stats.filtered, " Linked: ", stats.linked, " Removed: ", removed, " Bytes Copied: ", stats.bytes)
+	Sugar.Infow(msg, "src", src, "dst", dst, "folder_copied", stats.folders, "files_copied", stats.files, "files_skipped", stats.skipped,
+		"files_filtered", stats.filtered, "files_linked", stats.linked, "files_removed", removed, "bytes_copied", stats.bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	if DryRun {
+		return nil
+	}
+
+	// The sidecar manifest reuses the hashes of the files that haven't changed since the previous run.
+	prevDir := dst
+	if linkDest != "" {
+		prevDir = linkDest
+	}
+	if _, err := writeDirManifest(dst, prevDir); err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	if opts.snapshot {
+		// The snapshot is complete, only then the "latest" symlink points at it.
+		if err := updateLatest(dst); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		}
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(copydirCmd)
+	addSnapshotFlags(copydirCmd, &copydirOpts)
+	copydirCmd.Flags().BoolVar(&copydirOpts.incremental, "incremental", false, "skip the files that have the same size and modification time in dst (default from default.incremental)")
+	copydirCmd.Flags().BoolVar(&copydirOpts.mirror, "mirror", false, "delete the files of dst that aren't in src")
+	copydirCmd.Flags().StringVar(&copydirOpts.quarantine, "quarantine", "", "move the files removed by --mirror into this folder instead of deleting them")
+	copydirCmd.Flags().StringVar(&copydirOpts.deleteLimit, "delete-limit", "", `abort --mirror when it would remove more than N files or N% of the files, e.g. 100 or 10%`)
+	copydirCmd.Flags().BoolVar(&copydirOpts.checksum, "checksum", false, "compare the unchanged files by their SHA-256 hash as well as the size and modification time")
+	addFilterFlags(copydirCmd, &copydirOpts.filters)
+	copydirCmd.Flags().BoolVar(&copydirOpts.verify, "verify", false, "read back every copied file and compare its SHA-256 hash with the source")
 }
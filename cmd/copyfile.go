@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -31,7 +32,12 @@ var copyfileCmd = &cobra.Command{
 	Use:   "copyfile",
 	Short: "Copy a single file without a compression",
 	Long: `copyfile command is to copy the individual or a specific file from a valid source folder or a directory.
-Take note that, it will replace the existing file and its contents to the destination file.
+Take note that, it will replace the existing file and its contents to the destination file,
+unless the --snapshot flag or the default.snapshot setting is on, then each run writes into its own
+timestamped folder "dst/<job>/<YYYY-MM-DD_HHMMSS>" with a "latest" symlink pointing at the newest one.
+
+With the --verify flag, the copied file is read back and compared with the SHA-256 hash of the source
+computed while it was copied, it's copied once again when it doesn't match, then the copy fails.
 
 It must have a valid and absolute path for the source and its destination folder or directory.
 The Source and Destination paths should contains the "" space "" characters with one space in between to separate them.
@@ -50,29 +56,100 @@ Or in Linux:
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		src := filepath.FromSlash(args[0])
 		dst := filepath.FromSlash(args[1])
+		resolveSnapshotFlags(cmd, &copyfileOpts, src)
 
-		msg := `Starts copying the single file:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		// Errors are already reported to the user's console and the logs.
+		runCopyFile(src, dst, copyfileOpts)
+	},
+}
 
-		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
-		// depends on the user's OS using the filepath.FromSlash organic Go's library.
-		dest := filepath.FromSlash(filepath.Join(args[1], filepath.Base(src)))
+// copyfileOpts is the --snapshot, --job and --verify flags of the copyfile command.
+var copyfileOpts backupOptions
 
-		// Starts copying the single file.
-		if err := kopy.CopyFile(src, dest, dst, Sugar); err != nil {
+// runCopyFile copies a single file into the dst folder.
+func runCopyFile(src, dst string, opts backupOptions) (err error) {
+	msg := `Starts copying the single file:`
+	fmt.Println(msg, src)
+	Sugar.Infow(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	// Each snapshot has its own timestamped folder in the job folder.
+	if opts.snapshot {
+		var snapDir string
+		if snapDir, err = newSnapshotDir(dst, opts.job); err != nil {
 			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			return
+			return err
+		}
+		dst = snapDir
+
+		// A failed snapshot is removed, otherwise it'd be the newest snapshot to keep.
+		if !DryRun {
+			defer func() {
+				if err != nil {
+					os.RemoveAll(snapDir)
+				}
+			}()
 		}
+	}
 
-		// Give some info back to the user's console and the logs as well.
-		msg = `Successfully copied the file:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "dst", dest, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-	},
+	// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
+	// depends on the user's OS using the filepath.FromSlash organic Go's library.
+	dest := filepath.FromSlash(filepath.Join(dst, filepath.Base(src)))
+
+	if DryRun {
+		info, err := os.Stat(src)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return err
+		}
+		dryRunAction("copy file", dest, info.Size())
+		msg = dryRunMsg(`Successfully copied the file:`)
+		fmt.Println(msg, src, " Bytes Copied: ", info.Size())
+		Sugar.Infow(msg, "src", src, "dst", dest, "bytes_copied", info.Size(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return nil
+	}
+
+	// Starts copying the single file.
+	if err := copySingleFile(src, dest, dst, opts.verify); err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	// Give some info back to the user's console and the logs as well.
+	msg = `Successfully copied the file:`
+	fmt.Println(msg, src)
+	Sugar.Infow(msg, "src", src, "dst", dest, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	if opts.snapshot {
+		// The snapshot is complete, only then the "latest" symlink points at it.
+		if err := updateLatest(dst); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		}
+	}
+	return nil
+}
+
+// copySingleFile copies the src file as dest in the dst folder, with the verify option the copy is read
+// back and compared with the source.
+func copySingleFile(src, dest, dst string, verify bool) error {
+	if !verify {
+		return kopy.CopyFile(src, dest, dst, Sugar)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	return copyFileVerified(src, dest, info)
 }
 
 func init() {
 	rootCmd.AddCommand(copyfileCmd)
+	addSnapshotFlags(copyfileCmd, &copyfileOpts)
+	copyfileCmd.Flags().BoolVar(&copyfileOpts.verify, "verify", false, "read back the copied file and compare its SHA-256 hash with the source")
 }
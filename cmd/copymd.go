@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -33,6 +34,15 @@ var copymdCmd = &cobra.Command{
 	Use:   "copymd",
 	Short: "Copy the latest files from a specified directory based on the modified date and time",
 	Long: `copymd command will copy the latest files including the sub-folders files based on the modified date and time from the specified folder.
+The "`+ManifestName+`" manifest with the SHA-256 hash of every file in the destination folder is updated after each run.
+With the global --dry-run flag, the files that would be copied are only printed and logged.
+
+Each job, named by the --job flag or the source folder name, keeps its last successful run in the
+"state/<job>.json" file, the next run copies the files changed since then, even when a scheduled run was
+skipped. The first run copies the files of the last copy_mod_files_num_days days, and the --full flag
+copies every file. With the --checksum flag or the default.copymd_checksum setting, the SHA-256 hash of
+the copied files is kept in the state file too, the files that haven't changed since are skipped and the
+files that aren't in the state file yet are copied whatever their time.
 
 Open the "config.yaml" configuration file, you can change the following default settings such as:
 
@@ -41,6 +51,17 @@ default:
 	
 	ignore:
 		file_type_or_folder_name: .db, folder_name # You can specify file extentions or folder name seperated with comma.
+		patterns: ["**/cache/", "/build", "!keep.log"] # gitignore-style patterns, the last matching one wins.
+
+The --since and --until flags copy the files of another time window, e.g. to re-run a backup after an
+outage, each one is a date, e.g. 2020-06-01 or RFC3339, or a duration before now, e.g. 36h or 7d.
+The --time-field flag compares the ctime, the last change of the contents or the metadata, or the btime,
+the creation time, instead of the modification time. Not every OS and file system keep them.
+
+The --min-size, --max-size, --newer-than, --older-than and --only-ext flags narrow down the latest files by
+their size, modification date or extension, e.g. --only-ext .log to only copy the recent logs.
+
+A ".gokopyignore" file in the source folder or any of its sub-folders adds its own gitignore-style patterns.
 
 Example of a valid directory path in Windows:
 "C:\source_folder_to_compress" "D:\backup_destination"
@@ -53,8 +74,6 @@ Or in Linux:
 "/root/src" "/root/dst"`,
 	Args: cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		NumFilesCopied = 0 // Reset this variable
-
 		// Get the default value for the "copy_mod_files_num_days" setting.
 		modDays := viper.Get("default.copy_mod_files_num_days")
 		mDays := modDays.(int)
@@ -62,34 +81,291 @@ Or in Linux:
 			mDays = -1
 		}
 
-		// Get the list of ignored file types.
-		IgnoreFileTypes = viper.Get("ignore.file_type_or_folder_name")
-		IGFT := fmt.Sprint(IgnoreFileTypes)
-		IgnoreFT = strings.Split(IGFT, ",")
-
 		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		src := filepath.FromSlash(args[0])
 		dst := filepath.FromSlash(args[1])
 
-		msg := `Starts copying the latest files from:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		opts := copymdOpts
+		if opts.job == "" {
+			opts.job = kopy.FileNameWOExt(filepath.Base(src))
+		}
+		if !cmd.Flags().Changed("checksum") {
+			opts.checksum = viper.GetBool("default.copymd_checksum")
+		}
+
+		// Errors are already reported to the user's console and the logs.
+		runCopyMD(src, dst, mDays, nil, opts)
+	},
+}
+
+// copymdOptions is the job, the time window and the filters of the copymd command.
+type copymdOptions struct {
+	job       string      // Name of the "state/<job>.json" state file, no state is kept when empty
+	full      bool        // Copy every file, whatever the state file or the time window
+	checksum  bool        // Skip the files that have the same SHA-256 hash as when they were last copied
+	since     string      // Start of the time window, a date or a duration before now, the last run or "mDays" days when empty
+	until     string      // End of the time window, a date or a duration before now, now when empty
+	timeField string      // File time compared with the window: mtime, ctime or btime
+	filters   filterFlags // Size, modification time and extension filters
+}
 
-		// Starts copying the latest files from.
-		if err := kopy.WalkDIRModLatest(src, dst, mDays, IsLogCopiedFile, IgnoreFT, Sugar); err != nil {
+// copymdOpts is the --job, --full, --checksum, --since, --until, --time-field and filter flags of the copymd command.
+var copymdOpts copymdOptions
+
+// runCopyMD copies the files changed since the last successful run of the job, or within the last "mDays"
+// days on its first run, it's shared by the copymd command and the scheduled copymd_daily and
+// copymd_frequently backup items, "ignore" is added to the ignore list. The --since and --until flags of
+// opts set another time window.
+func runCopyMD(src, dst string, mDays int, ignore []string, opts copymdOptions) error {
+	NumFilesCopied = 0 // Reset this variable
+
+	now := time.Now()
+	state := loadJobState(opts.job, src, dst)
+	lastRun := time.Time{}
+	if state != nil && !opts.full {
+		lastRun = state.LastRun
+	}
+	window, err := parseTimeWindow(opts, mDays, lastRun, now)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+	filter, err := parseFilter(opts.filters)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	// Get the list of ignored file types.
+	IgnoreFT = IgnoreList(ignore)
+
+	// The hashes of the files copied by the previous runs, only with the checksum option.
+	var hashes map[string]fileHash
+	if opts.checksum {
+		hashes = map[string]fileHash{}
+		if state != nil && state.Files != nil && !opts.full {
+			hashes = state.Files
+		}
+	}
+
+	msg := `Starts copying the latest files from:`
+	if opts.full {
+		fmt.Println(msg, src, " Every file")
+	} else {
+		fmt.Println(msg, src, " Since: ", window.since.Format(itrlog.LogTimeFormat))
+	}
+	Sugar.Infow(msg, "src", src, "dst", dst, "job", opts.job, "full", opts.full, "since", window.since.Format(itrlog.LogTimeFormat), "until", window.until.Format(itrlog.LogTimeFormat),
+		"time_field", window.field, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	// Starts copying the latest files from.
+	stats, err := copyModifiedFiles(src, dst, window, IgnoreFT, filter, hashes)
+	NumFilesCopied = stats.files
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	// The sidecar manifest covers every file of the destination folder, not only the latest ones.
+	if !DryRun {
+		if _, err := writeDirManifest(dst, dst); err != nil {
 			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			return
+			return err
 		}
 
-		// Give some info back to the user's console and the logs as well.
-		msg = `Successfully copied the latest files from:`
-		fmt.Println(msg, src, " Number of Files Copied: ", NumFilesCopied)
-		Sugar.Infow(msg, "src", src, "dst", dst, "copied_files", NumFilesCopied, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-	},
+		// A run of an explicit window that ends before now doesn't move the last successful run, nor does a
+		// --since after it, the files changed in between haven't been copied.
+		if opts.job != "" && opts.until == "" {
+			lastRun := now
+			if state != nil && !opts.full && window.since.After(state.LastRun) {
+				lastRun = state.LastRun
+			}
+			st := &copymdState{Job: opts.job, Src: absPath(src), Dst: absPath(dst), LastRun: lastRun, Files: hashes}
+			if err := st.write(); err != nil {
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				return err
+			}
+		}
+	}
+
+	// Give some info back to the user's console and the logs as well.
+	msg = dryRunMsg(`Successfully copied the latest files from:`)
+	fmt.Println(msg, src, " Number of Files Copied: ", NumFilesCopied, " Skipped: ", stats.skipped, " Filtered: ", stats.filtered, " Bytes Copied: ", stats.bytes)
+	Sugar.Infow(msg, "src", src, "dst", dst, "copied_files", NumFilesCopied, "files_skipped", stats.skipped, "files_filtered", stats.filtered, "bytes_copied", stats.bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	return nil
+}
+
+// timeWindow is the time window of the files copied by copymd.
+type timeWindow struct {
+	since time.Time
+	until time.Time
+	field string // mtime, ctime or btime
+}
+
+// parseTimeWindow returns the time window of the copymd options, from the last successful run until now
+// by default, or from the last "mDays" days when lastRun is zero. The full option has no start.
+func parseTimeWindow(opts copymdOptions, mDays int, lastRun, now time.Time) (timeWindow, error) {
+	w := timeWindow{since: now.AddDate(0, 0, mDays), until: now, field: opts.timeField}
+	if !lastRun.IsZero() {
+		w.since = lastRun
+	}
+	if w.field == "" {
+		w.field = TimeFieldMtime
+	}
+	if !inList(timeFields, w.field) {
+		return w, fmt.Errorf("invalid --time-field %q, it must be one of: %s", w.field, strings.Join(timeFields, ", "))
+	}
+	if opts.full && opts.since != "" {
+		return w, fmt.Errorf("--full can't be used with --since, it copies every file")
+	}
+	var err error
+	if opts.full {
+		w.since = time.Time{}
+	} else if opts.since != "" {
+		if w.since, err = parseTimeArg(opts.since, now); err != nil {
+			return w, fmt.Errorf("invalid --since: %v", err)
+		}
+	}
+	if opts.until != "" {
+		if w.until, err = parseTimeArg(opts.until, now); err != nil {
+			return w, fmt.Errorf("invalid --until: %v", err)
+		}
+	}
+	if !w.since.Before(w.until) {
+		return w, fmt.Errorf("the --since time %s must be before the --until time %s",
+			w.since.Format(itrlog.LogTimeFormat), w.until.Format(itrlog.LogTimeFormat))
+	}
+	return w, nil
+}
+
+// copyModifiedFiles copies the files of the src folder whose time field is within the time window into
+// the same sub-folders of the dst folder, they keep their modification time. On a dry run, the files are
+// only reported. The files left out by the filter aren't copied, the files that can't be copied are
+// reported and the rest of the files are still copied.
+//
+// When hashes isn't nil, the files that aren't in it are copied whatever the time window, the files that
+// have the same size and SHA-256 hash as when they were last copied are skipped, hashes is updated with the
+// copied files and the files that no longer exist are removed.
+func copyModifiedFiles(src, dst string, w timeWindow, ignore []string, filter *fileFilter, hashes map[string]fileHash) (copyStats, error) {
+	var stats copyStats
+	seen := map[string]bool{}
+	ig := newIgnoreMatcher(src, ignore)
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ig.match(file, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// The symlinks are copied as the files they point at.
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(file); err != nil {
+				stats.failed++
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				return nil
+			}
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		seen[filepath.ToSlash(rel)] = true
+
+		t, err := fileTime(file, fi, w.field)
+		if err != nil {
+			stats.failed++
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return nil
+		}
+		// A file never copied in the checksum mode is copied whatever its time, e.g. one restored with an old time.
+		_, known := hashes[filepath.ToSlash(rel)]
+		if (t.Before(w.since) || t.After(w.until)) && (hashes == nil || known) {
+			return nil
+		}
+		if !filter.keep(fi.Name(), fi) {
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		sum := ""
+		if hashes != nil {
+			if sum, err = fileSHA256(file); err != nil {
+				stats.failed++
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				return nil
+			}
+			if prev, ok := hashes[filepath.ToSlash(rel)]; ok && prev.Size == fi.Size() && prev.SHA256 == sum {
+				stats.skipped++
+				if DryRun {
+					dryRunAction("skip unchanged file", target, -1)
+				} else if IsLogCopiedFile {
+					fmt.Println("skipped unchanged file: ", fi.Name())
+					Sugar.Infow("skipped_file", "name", fi.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				}
+				return nil
+			}
+		}
+
+		if DryRun {
+			dryRunAction("copy file", target, fi.Size())
+		} else {
+			err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+			if err == nil {
+				err = copyFileTimes(file, target, fi)
+			}
+			if err != nil {
+				stats.failed++
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				return nil
+			}
+			// Only log when it's true
+			if IsLogCopiedFile {
+				fmt.Println("copied file: ", fi.Name())
+				Sugar.Infow("copied_file", "name", fi.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			}
+		}
+		if hashes != nil {
+			hashes[filepath.ToSlash(rel)] = fileHash{Size: fi.Size(), SHA256: sum}
+		}
+		stats.files++
+		stats.bytes += fi.Size()
+		return nil
+	})
+	stats.filtered = filter.filteredCount()
+	for rel := range hashes {
+		if err == nil && !seen[rel] {
+			delete(hashes, rel)
+		}
+	}
+	if err == nil && stats.failed > 0 {
+		err = fmt.Errorf("%d files couldn't be copied from %s", stats.failed, src)
+	}
+	return stats, err
 }
 
 func init() {
 	rootCmd.AddCommand(copymdCmd)
+	copymdCmd.Flags().StringVar(&copymdOpts.job, "job", "", "name of the state/<job>.json state file of the last successful run (default the src folder name)")
+	copymdCmd.Flags().BoolVar(&copymdOpts.full, "full", false, "copy every file, whatever the last successful run")
+	copymdCmd.Flags().BoolVar(&copymdOpts.checksum, "checksum", false, "skip the files that have the same SHA-256 hash as when they were last copied (default from default.copymd_checksum)")
+	copymdCmd.Flags().StringVar(&copymdOpts.since, "since", "", "copy the files since this date or duration before now, e.g. 2020-06-01T08:00:00Z, 36h or 7d (default the last successful run)")
+	copymdCmd.Flags().StringVar(&copymdOpts.until, "until", "", "copy the files until this date or duration before now (default now)")
+	copymdCmd.Flags().StringVar(&copymdOpts.timeField, "time-field", TimeFieldMtime, "file time of the window: mtime, ctime or btime")
+	addFilterFlags(copymdCmd, &copymdOpts.filters)
 }
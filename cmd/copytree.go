@@ -0,0 +1,291 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+)
+
+// copyStats counts the folders and files of a copyTree run.
+type copyStats struct {
+	folders  int   // Folders copied
+	files    int   // Files copied
+	skipped  int   // Unchanged files that are already in dst, incremental mode only
+	linked   int   // Unchanged files hard-linked from the previous snapshot
+	failed   int   // Files or folders that couldn't be copied
+	filtered int   // Files left out by the size, date or extension filters
+	bytes    int64 // Bytes of the copied files
+}
+
+// treeCopier copies a directory tree, it keeps the modification time of the copied files so the next
+// snapshot can tell which files haven't changed since the previous one.
+type treeCopier struct {
+	linkDest    string         // Previous snapshot to hard-link the unchanged files from, empty to copy every file
+	checksum    bool           // Compare the files by their SHA-256 hash as well as the size and modification time
+	verify      bool           // Re-read every copied file and compare its hash with the source
+	incremental bool           // Skip the files that are unchanged in dst
+	dryRun      bool           // Only report what would be copied, linked or skipped
+	ignore      *ignoreMatcher // Skips the ignored files and folders
+	filter      *fileFilter    // Skips the files left out by the filter flags, nil to copy every file
+	logCopied   bool           // Log every copied or linked file and folder
+	stats       copyStats
+}
+
+// copyTree copies the entire src folder into the dst folder, the unchanged files of the linkDest
+// folder are hard-linked instead of copied when it's not empty. The checksum, verify and incremental
+// options are taken from opts, the files left out by the filter aren't copied.
+func copyTree(src, dst, linkDest string, opts backupOptions, ignore []string, filter *fileFilter) (copyStats, error) {
+	c := &treeCopier{linkDest: linkDest, checksum: opts.checksum, verify: opts.verify, incremental: opts.incremental,
+		ignore: newIgnoreMatcher(src, ignore), filter: filter, logCopied: IsLogCopiedFile, dryRun: DryRun}
+	err := c.copyDir(src, dst, "")
+	c.stats.filtered = filter.filteredCount()
+	if err != nil {
+		return c.stats, err
+	}
+	if c.stats.failed > 0 {
+		return c.stats, fmt.Errorf("%d files or folders couldn't be copied from %s", c.stats.failed, src)
+	}
+	return c.stats, nil
+}
+
+// copyDir copies the src folder into the dst folder, rel is the path of the folder relative to the top src folder.
+func (c *treeCopier) copyDir(src, dst, rel string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			dryRunAction("create folder", dst, -1)
+		}
+	} else if err := os.MkdirAll(dst, srcInfo.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	fds, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fds {
+		srcfp := filepath.Join(src, fd.Name())
+		dstfp := filepath.Join(dst, fd.Name())
+		relfp := filepath.Join(rel, fd.Name())
+		if c.ignore.match(srcfp, fd.IsDir()) {
+			continue
+		}
+
+		if fd.IsDir() {
+			if err := c.copyDir(srcfp, dstfp, relfp); err != nil {
+				c.fail(err)
+				continue
+			}
+			c.stats.folders++
+			if c.logCopied && !c.dryRun {
+				Sugar.Infow("copied_folder", "name", fd.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				fmt.Println("copied folder: ", fd.Name())
+			}
+			continue
+		}
+
+		// The symlinks are copied as the files they point at, the same as the regular copydir.
+		info := fd
+		if fd.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(srcfp); err != nil {
+				c.fail(err)
+				continue
+			}
+		}
+		if !c.filter.keep(fd.Name(), info) {
+			continue
+		}
+
+		if c.incremental && c.sameFile(srcfp, dstfp, info) {
+			c.stats.skipped++
+			if c.dryRun {
+				dryRunAction("skip unchanged file", dstfp, -1)
+			} else if c.logCopied {
+				Sugar.Infow("skipped_file", "file", fd.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				fmt.Println("skipped unchanged file: ", fd.Name())
+			}
+			continue
+		}
+
+		if c.linkDest != "" && c.linkFile(srcfp, dstfp, filepath.Join(c.linkDest, relfp), info) {
+			c.stats.linked++
+			if c.dryRun {
+				dryRunAction("hard-link unchanged file", dstfp, -1)
+			} else if c.logCopied {
+				Sugar.Infow("linked_file", "file", fd.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				fmt.Println("linked file: ", fd.Name())
+			}
+			continue
+		}
+
+		if c.dryRun {
+			c.stats.files++
+			c.stats.bytes += info.Size()
+			dryRunAction("copy file", dstfp, info.Size())
+			continue
+		}
+
+		copyFile := copyFileTimes
+		if c.verify {
+			copyFile = copyFileVerified
+		}
+		if err := copyFile(srcfp, dstfp, info); err != nil {
+			c.fail(err)
+			continue
+		}
+		c.stats.files++
+		c.stats.bytes += info.Size()
+		if c.logCopied {
+			Sugar.Infow("copied_file", "file", fd.Name(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			fmt.Println("copied file: ", fd.Name())
+		}
+	}
+
+	// The folder's modification time changes while its files are copied, so it's set at the end.
+	if !c.dryRun {
+		os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	return nil
+}
+
+// linkFile hard-links the previous file into dst when it's unchanged, it returns false when the file
+// must be copied, e.g. it's new, changed or the destination doesn't support hard links.
+func (c *treeCopier) linkFile(src, dst, prev string, info os.FileInfo) bool {
+	return c.sameFile(src, prev, info) && (c.dryRun || os.Link(prev, dst) == nil)
+}
+
+// sameFile checks if the other file is a copy of the src file by its size, permissions and modification
+// time, and by its SHA-256 hash as well with the checksum option.
+func (c *treeCopier) sameFile(src, other string, info os.FileInfo) bool {
+	otherInfo, err := os.Lstat(other)
+	if err != nil || !otherInfo.Mode().IsRegular() || !sameFileInfo(info, otherInfo) {
+		return false
+	}
+	if c.checksum {
+		srcSum, err := fileSHA256(src)
+		if err != nil {
+			return false
+		}
+		if otherSum, err := fileSHA256(other); err != nil || otherSum != srcSum {
+			return false
+		}
+	}
+	return true
+}
+
+// fail reports a file or folder that couldn't be copied, the rest of the files are still copied.
+func (c *treeCopier) fail(err error) {
+	c.stats.failed++
+	fmt.Println(err)
+	Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+}
+
+// sameFileInfo checks if two files have the same size, permissions and modification time, to the second
+// because some file systems don't keep the nanoseconds.
+func sameFileInfo(a, b os.FileInfo) bool {
+	return a.Size() == b.Size() && a.Mode().Perm() == b.Mode().Perm() && a.ModTime().Unix() == b.ModTime().Unix()
+}
+
+// copyFileTimes copies a single file and keeps its permissions and modification time.
+func copyFileTimes(src, dst string, info os.FileInfo) error {
+	return copyFileHash(src, dst, info, nil)
+}
+
+// copyFileVerified copies a single file the same as copyFileTimes, then reads the copy back and compares
+// its SHA-256 hash with the one of the source computed while it was copied. The file is copied once
+// again when they don't match, e.g. it was truncated by a network share.
+func copyFileVerified(src, dst string, info os.FileInfo) error {
+	for attempt := 1; ; attempt++ {
+		h := sha256.New()
+		if err := copyFileHash(src, dst, info, h); err != nil {
+			return err
+		}
+		srcSum := hex.EncodeToString(h.Sum(nil))
+		dstSum, err := fileSHA256(dst)
+		if err == nil && dstSum == srcSum {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("SHA-256 of the copy doesn't match the source")
+		}
+		if attempt == 2 {
+			return fmt.Errorf("%s: %v", dst, err)
+		}
+		fmt.Println("retry copying the file: ", src, "-", err)
+		Sugar.Errorw("retry copying the file", "src", src, "dst", dst, "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	}
+}
+
+// copyFileHash copies a single file and keeps its permissions and modification time, the contents of
+// the source are written to h as well when it isn't nil.
+func copyFileHash(src, dst string, info os.FileInfo, h hash.Hash) error {
+	srcfd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcfd.Close()
+
+	var r io.Reader = srcfd
+	if h != nil {
+		r = io.TeeReader(srcfd, h)
+	}
+
+	// Replace the file instead of writing into it, it may be hard-linked into the other snapshots.
+	os.Remove(dst)
+	dstfd, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dstfd, r); err != nil {
+		dstfd.Close()
+		return err
+	}
+	if err := dstfd.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// fileSHA256 returns the hex encoded SHA-256 hash of the file's contents.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -17,12 +17,10 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/itrepablik/itrlog"
-	"github.com/itrepablik/kopy"
 
 	"github.com/spf13/cobra"
 )
@@ -30,10 +28,15 @@ import (
 // dcdirCmd represents the dcdir command
 var dcdirCmd = &cobra.Command{
 	Use:   "dcdir",
-	Short: "Decompress any single tar.gz file",
+	Short: "Decompress any single compressed folder archive",
 	Long: `The dcdir command will decompress the specified directory or a folder including the sub-folders
-and its contents as well. Only the .tar.gz compress files will be extracted by this dcdir command in relation
-to the comdir command in which it will compress the entire folder.
+and its contents as well, in relation to the comdir command in which it will compress the entire folder.
+The archive format is detected from its content, not its file extension: tar.gz, tar.zst, tar.xz, tar.lz4,
+tar.bz2, tar or zip. The folder is extracted beside the archive, named after it without the file extension,
+unless the destination folder is given as the second argument or by the --into flag.
+
+The entries with an absolute path, a ".." that escapes the destination folder, or a symlink pointing outside
+of it are rejected, and reported in the logs, the rest of the archive is still extracted.
 
 Example of a valid directory path in Windows:
 "C:\source_folder\folder_name.tar.gz"
@@ -45,35 +48,50 @@ Example of a valid directory path in Linux:
 "/home/user/source_folder_to_compress/folder_name.tar.gz"
 
 Or in Linux:
-"/root/src/folder_name.tar.gz"`,
-	Args: cobra.MinimumNArgs(1),
+"/root/src/folder_name.tar.gz"
+
+With a destination folder:
+"/root/src/folder_name.tar.gz" "/root/restore"`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		src := filepath.FromSlash(args[0])
 
+		dst := trimArchiveExt(src)
+		switch {
+		case len(args) == 2 && dcdirInto != "" && filepath.Clean(args[1]) != filepath.Clean(dcdirInto):
+			fmt.Println("The destination folder must be either the second argument or the --into flag, not both.")
+			return
+		case len(args) == 2:
+			dst = filepath.FromSlash(args[1])
+		case dcdirInto != "":
+			dst = filepath.FromSlash(dcdirInto)
+		}
+
 		msg := `Start decompressing the folder or a directory:`
 		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		Sugar.Infow(msg, "src", src, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 
-		r, err := os.Open(src)
+		st, format, err := extractArchive(src, dst, extractOptions{stripRoot: true})
 		if err != nil {
-			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
-			fmt.Println("error")
-			return
-		}
-		if err := kopy.ExtractTarGz(r, src, IsLogCopiedFile, Sugar); err != nil {
 			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 			return
 		}
 
-		msg = `Done decompressing the folder or a directory:`
-		fmt.Println(msg, src)
-		Sugar.Infow(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		msg = dryRunMsg(`Done decompressing the folder or a directory:`)
+		fmt.Println(msg, src, " Format: ", format.name, " Destination: ", dst, " Number of Files Extracted: ", st.files, " Rejected: ", st.rejected,
+			" Bytes Extracted: ", st.bytes)
+		Sugar.Infow(msg, "src", src, "dst", dst, "format", format.name, "files_extracted", st.files, "folders_extracted", st.folders,
+			"rejected", st.rejected, "bytes_extracted", st.bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 	},
 }
 
+// dcdirInto is the --into flag of the dcdir command.
+var dcdirInto string
+
 func init() {
 	rootCmd.AddCommand(dcdirCmd)
+	dcdirCmd.Flags().StringVar(&dcdirInto, "into", "", "destination folder of the extracted files (default is beside the archive)")
 }
@@ -18,10 +18,10 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/itrepablik/itrlog"
-	"github.com/itrepablik/kopy"
 
 	"github.com/spf13/cobra"
 )
@@ -29,9 +29,20 @@ import (
 // dcfileCmd represents the dcfile command
 var dcfileCmd = &cobra.Command{
 	Use:   "dcfile",
-	Short: "Decompress any single zip file",
-	Long: `dcfile command will decompress any single zip compression file format.
-Only the .zip files will be decompressed by this command.
+	Short: "Decompress any single compressed file",
+	Long: `dcfile command will decompress any single compressed file, in relation to the comfile command.
+The archive format is detected from its content, not its file extension: zip, tar.gz, tar.zst, tar.xz,
+tar.lz4, tar.bz2 or tar. The files are extracted into a folder beside the archive, named after it without
+the file extension, unless the destination folder is given as the second argument.
+
+The entries with an absolute path, a ".." that escapes the destination folder, or a symlink pointing outside
+of it are rejected, and reported in the logs. The --on-conflict flag sets what happens to the files that
+already exist in the destination folder:
+
+	overwrite   replace the existing file, the default
+	skip        keep the existing file
+	rename      extract next to the existing file as name_1.ext, name_2.ext, ...
+	newer       replace the existing file only when the archived one is newer
 
 Example of a valid directory path in Windows:
 "C:\source_folder\filename.zip"
@@ -40,29 +51,48 @@ Or using the network directories, example:
 "\\hostname_or_ip\source_folder\filename.zip"
 
 Or in Linux:
-"/root/src/filename.zip"`,
-	Args: cobra.MinimumNArgs(1),
+"/root/src/filename.zip"
+
+With a destination folder:
+"/root/src/filename.zip" "/root/restore" --on-conflict skip`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		// To make directory path separator a universal, in Linux "/" and in Windows "\" to auto change
 		// depends on the user's OS using the filepath.FromSlash organic Go's library.
 		src := filepath.FromSlash(args[0])
 
+		dst := trimArchiveExt(src)
+		if len(args) == 2 {
+			dst = filepath.FromSlash(args[1])
+		}
+		if !inList(conflictPolicies, dcfileOnConflict) {
+			fmt.Println("--on-conflict must be one of:", strings.Join(conflictPolicies, ", "))
+			return
+		}
+
 		msg := `Start decompressing the file:`
 		fmt.Println(msg, src)
-		Sugar.Errorw(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		Sugar.Infow(msg, "src", src, "dst", dst, "on_conflict", dcfileOnConflict, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 
-		if err := kopy.Unzip(src, IsLogCopiedFile, Sugar); err != nil {
+		st, format, err := extractArchive(src, dst, extractOptions{onConflict: dcfileOnConflict})
+		if err != nil {
 			fmt.Println(err)
 			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 			return
 		}
 
-		msg = `Done decompressing the file:`
-		fmt.Println(msg, src)
-		Sugar.Errorw(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		msg = dryRunMsg(`Done decompressing the file:`)
+		fmt.Println(msg, src, " Format: ", format.name, " Destination: ", dst, " Number of Files Written: ", st.files, " Skipped: ", st.skipped,
+			" Rejected: ", st.rejected, " Bytes Written: ", st.bytes)
+		Sugar.Infow(msg, "src", src, "dst", dst, "format", format.name, "files_written", st.files, "skipped", st.skipped,
+			"rejected", st.rejected, "bytes_written", st.bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
 	},
 }
 
+// dcfileOnConflict is the --on-conflict flag of the dcfile command.
+var dcfileOnConflict string
+
 func init() {
 	rootCmd.AddCommand(dcfileCmd)
+	dcfileCmd.Flags().StringVar(&dcfileOnConflict, "on-conflict", ConflictOverwrite, "what to do with the existing files: "+strings.Join(conflictPolicies, ", "))
 }
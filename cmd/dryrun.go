@@ -0,0 +1,53 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// dryRunAction reports an action that a command would take without the --dry-run flag, the size is
+// the number of bytes it would write, or -1 when it doesn't write anything.
+func dryRunAction(action, path string, size int64) {
+	if size < 0 {
+		fmt.Println("[dry-run] would", action+":", path)
+	} else {
+		fmt.Println("[dry-run] would", action+":", path, fmt.Sprintf("(%d bytes)", size))
+	}
+	Sugar.Infow("dry_run", "action", action, "path", path, "size", size, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+}
+
+// dryRunMsg returns the message of a finished command, it tells that nothing was written on a dry run.
+func dryRunMsg(msg string) string {
+	if DryRun {
+		return "[dry-run] Nothing was written. " + msg
+	}
+	return msg
+}
+
+// rejectDryRun stops the commands that don't support the --dry-run flag, rather than writing anything.
+func rejectDryRun(cmd *cobra.Command) {
+	if DryRun {
+		fmt.Println("The --dry-run flag isn't supported by the", cmd.CommandPath(), "command.")
+		os.Exit(1)
+	}
+}
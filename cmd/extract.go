@@ -0,0 +1,510 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// extractCmd represents the extract command
+var extractCmd = &cobra.Command{
+	Use:   "extract",
+	Short: "Extract only the matching files or folders of an archive",
+	Long: `extract command streams through the archive and extracts only the entries that match at least one of
+the paths or glob patterns, it's the selective restore of the comdir and comfile archives without
+decompressing everything. The archive format is detected from its content like the dcdir command.
+
+The paths are relative to the archived folder, a folder extracts everything inside of it, and the glob
+patterns use the '*', '?' and '[...]' wildcards. A pattern without any '/' also matches the file names in
+every sub-folder. The files are extracted beside the archive, into a folder named after it without the file
+extension, unless the --into flag is set, example:
+"D:\backup\documents.tar.gz" "reports/2020/budget.xlsx" "*.docx" --into "D:\restored"`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := filepath.FromSlash(args[0])
+		dst := trimArchiveExt(src)
+		if extractInto != "" {
+			dst = filepath.FromSlash(extractInto)
+		}
+		if !inList(conflictPolicies, extractOnConflict) {
+			fmt.Println("--on-conflict must be one of:", strings.Join(conflictPolicies, ", "))
+			os.Exit(1)
+		}
+		match, err := entryMatcher(args[1:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		msg := `Start extracting from the archive:`
+		fmt.Println(msg, src)
+		Sugar.Infow(msg, "src", src, "dst", dst, "patterns", args[1:], "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+		st, format, err := extractArchive(src, dst, extractOptions{stripRoot: true, onConflict: extractOnConflict, match: match})
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		if st.files == 0 && st.folders == 0 && st.skipped == 0 {
+			if !DryRun {
+				os.Remove(dst) // Only when it's still empty.
+			}
+			msg = `No entries of the archive match:`
+			fmt.Println(msg, strings.Join(args[1:], " "))
+			Sugar.Errorw(msg, "src", src, "patterns", args[1:], "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg = dryRunMsg(`Done extracting from the archive:`)
+		fmt.Println(msg, src, " Format: ", format.name, " Destination: ", dst, " Number of Files Extracted: ", st.files, " Skipped: ", st.skipped,
+			" Rejected: ", st.rejected, " Bytes Extracted: ", st.bytes)
+		Sugar.Infow(msg, "src", src, "dst", dst, "format", format.name, "files_extracted", st.files, "skipped", st.skipped,
+			"rejected", st.rejected, "bytes_extracted", st.bytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// The flags of the extract command.
+var (
+	extractInto       string
+	extractOnConflict string
+)
+
+// extractStats counts the entries of an extracted archive.
+type extractStats struct {
+	files    int
+	folders  int
+	skipped  int
+	rejected int
+	bytes    int64
+}
+
+// Policies of the existing files in the destination folder of an extraction.
+const (
+	ConflictOverwrite = "overwrite" // Replace the existing file
+	ConflictSkip      = "skip"      // Keep the existing file
+	ConflictRename    = "rename"    // Extract next to the existing file as name_1.ext, name_2.ext, ...
+	ConflictNewer     = "newer"     // Replace the existing file only when the archived one is newer
+)
+
+// conflictPolicies lists the valid --on-conflict flag values.
+var conflictPolicies = []string{ConflictOverwrite, ConflictSkip, ConflictRename, ConflictNewer}
+
+// extractOptions are the settings of an archive extraction.
+type extractOptions struct {
+	stripRoot  bool                   // Replace the top folder of the archive by the dst folder, the same as the dcdir command
+	onConflict string                 // One of the conflictPolicies, empty is the same as ConflictOverwrite
+	match      func(name string) bool // Only the matching entries are extracted when it's set
+}
+
+// extractArchive extracts the archive into the dst folder, its format is detected from its magic bytes.
+// The entries with an absolute path, a ".." escaping the dst folder, or a symlink pointing outside of it
+// are rejected and reported, the rest of the archive is still extracted.
+func extractArchive(src, dst string, opts extractOptions) (extractStats, *archiveFormat, error) {
+	var st extractStats
+	ar, format, err := openArchive(src)
+	if err != nil {
+		return st, nil, err
+	}
+	defer ar.Close()
+
+	if !DryRun {
+		if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+			return st, format, err
+		}
+	}
+	realDst, err := filepath.EvalSymlinks(dst)
+	if os.IsNotExist(err) && DryRun {
+		// Nothing in the folder it would create can be a symlink yet.
+		realDst, err = filepath.Clean(dst), nil
+	}
+	if err != nil {
+		return st, format, err
+	}
+
+	root := ""
+	first := true
+	var dirs []*archiveEntry
+	var links []pendingLink
+	for {
+		e, r, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return st, format, fmt.Errorf("%s: %v", src, err)
+		}
+
+		// The backslashes are path separators too, the zip archives made on Windows may have them.
+		name := strings.TrimSuffix(strings.ReplaceAll(e.name, `\`, "/"), "/")
+		if isManifestEntry(name) {
+			continue // The manifest is only checked by the test command.
+		}
+		if opts.stripRoot && first && e.mode.IsDir() {
+			root = name
+			first = false
+			continue
+		}
+		first = false
+		if root != "" {
+			if name == root {
+				continue
+			}
+			name = strings.TrimPrefix(name, root+"/")
+		}
+		if opts.match != nil && !opts.match(name) && (root == "" || !opts.match(root+"/"+name)) {
+			continue
+		}
+
+		target, err := safeEntryPath(realDst, name, e)
+		if err != nil {
+			st.rejected++
+			rejectEntry(e.name, err)
+			continue
+		}
+
+		if e.mode.IsDir() {
+			if DryRun {
+				st.folders++
+				continue
+			}
+			if err := os.MkdirAll(target, e.mode.Perm()|0700); err != nil {
+				return st, format, err
+			}
+			e.name = target
+			dirs = append(dirs, e)
+			st.folders++
+			continue
+		}
+		if !e.mode.IsRegular() && e.mode&os.ModeSymlink == 0 {
+			st.skipped++
+			fmt.Println("unknown type:", e.name)
+			Sugar.Errorw("unknown type", "file_type", e.name, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			continue
+		}
+		if e.mode&os.ModeSymlink != 0 && !DryRun {
+			links = append(links, pendingLink{name: name, entry: e})
+			continue
+		}
+
+		// The existing files are handled by the conflict policy, the folders are always merged.
+		target, skip := resolveConflict(target, e, opts.onConflict)
+		if skip {
+			st.skipped++
+			continue
+		}
+
+		if DryRun {
+			st.files++
+			st.bytes += e.size
+			dryRunAction("extract", target, e.size)
+			continue
+		}
+
+		if err := writeExtractedFile(target, r, e); err != nil {
+			return st, format, err
+		}
+
+		st.files++
+		st.bytes += e.size
+		// Only log when it's true
+		if IsLogCopiedFile {
+			fmt.Println("extracting to: ", target)
+			Sugar.Infow("extracting to: ", "dst", target, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		}
+	}
+
+	if err := extractSymlinks(realDst, links, opts.onConflict, &st); err != nil {
+		return st, format, err
+	}
+
+	// The folders get their modification time once their files are written, the deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i].name, dirs[i].modTime, dirs[i].modTime)
+	}
+	return st, format, nil
+}
+
+// pendingLink is a symlink entry of the archive, created once the files and folders are extracted.
+type pendingLink struct {
+	name   string // Slash separated path in the dst folder
+	entry  *archiveEntry
+	target string // Path of the created symlink
+}
+
+// extractSymlinks creates the symlinks after the files and folders, so nothing of the archive is written
+// through them. A symlink created later can change where an earlier one points, e.g. "l -> b/../x" then
+// "b -> .", so every symlink is checked again against the finished tree and removed when it escapes.
+func extractSymlinks(dst string, links []pendingLink, onConflict string, st *extractStats) error {
+	var created []*pendingLink
+	for i := range links {
+		l := &links[i]
+		target, err := safeEntryPath(dst, l.name, l.entry)
+		if err != nil {
+			st.rejected++
+			rejectEntry(l.entry.name, err)
+			continue
+		}
+		target, skip := resolveConflict(target, l.entry, onConflict)
+		if skip {
+			st.skipped++
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Symlink(l.entry.link, target); err != nil {
+			return err
+		}
+		l.target = target
+		created = append(created, l)
+		st.files++
+		// Only log when it's true
+		if IsLogCopiedFile {
+			fmt.Println("extracting to: ", target)
+			Sugar.Infow("extracting to: ", "dst", target, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		}
+	}
+
+	// Removing a symlink can change where the others point too, so it's repeated until none is removed.
+	for removed := true; removed; {
+		removed = false
+		for i, l := range created {
+			if l == nil || linkInside(dst, filepath.Dir(l.target), strings.ReplaceAll(l.entry.link, `\`, "/")) {
+				continue
+			}
+			if err := os.Remove(l.target); err != nil {
+				return err
+			}
+			created[i], removed = nil, true
+			st.files--
+			st.rejected++
+			rejectEntry(l.entry.name, fmt.Errorf("symlink %q points outside of the destination folder", l.entry.link))
+		}
+	}
+	return nil
+}
+
+// resolveConflict applies the conflict policy when the target already exists, it returns the path to write
+// the entry to, or true when the entry must be skipped.
+func resolveConflict(target string, e *archiveEntry, policy string) (string, bool) {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return target, false
+	}
+
+	switch {
+	case policy == ConflictRename:
+		ext := filepath.Ext(target)
+		base := strings.TrimSuffix(target, ext)
+		for n := 1; ; n++ {
+			renamed := fmt.Sprintf("%s_%d%s", base, n, ext)
+			if _, err := os.Lstat(renamed); os.IsNotExist(err) {
+				return renamed, false
+			}
+		}
+	case info.IsDir():
+		// A folder is never replaced by a file.
+		fmt.Println("skipped, a folder already exists: ", target)
+		Sugar.Errorw("skipped", "dst", target, "reason", "a folder already exists", "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return target, true
+	case policy == ConflictSkip, policy == ConflictNewer && !e.modTime.After(info.ModTime()):
+		if IsLogCopiedFile {
+			fmt.Println("skipped existing file: ", target)
+			Sugar.Infow("skipped existing file", "dst", target, "policy", policy, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		}
+		return target, true
+	}
+	return target, false
+}
+
+// entryMatcher returns the function that checks if an archive entry name matches one of the paths or
+// glob patterns, an entry inside a matching folder matches too.
+func entryMatcher(patterns []string) (func(name string) bool, error) {
+	var clean []string
+	for _, p := range patterns {
+		p = strings.Trim(path.Clean("/"+strings.ReplaceAll(p, `\`, "/")), "/")
+		if p == "" {
+			return nil, fmt.Errorf("empty path pattern")
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%q: %v", p, err)
+		}
+		clean = append(clean, p)
+	}
+
+	return func(name string) bool {
+		for _, p := range clean {
+			if !strings.Contains(p, "/") {
+				if ok, _ := path.Match(p, path.Base(name)); ok {
+					return true
+				}
+			}
+			// The name and each of its parent folders, e.g. "a/b/c", "a/b" and "a".
+			for n := name; n != "." && n != "/" && n != ""; n = path.Dir(n) {
+				if ok, _ := path.Match(p, n); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// safeEntryPath returns the path of the entry in the dst folder, or an error when the entry would be written
+// outside of it, by an absolute path, a ".." escape, a symlink pointing outside of the dst folder, or through
+// an existing symlink in the dst folder. The dst folder must be resolved already.
+func safeEntryPath(dst, name string, e *archiveEntry) (string, error) {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(filepath.FromSlash(name)) || hasVolumeName(name) {
+		return "", fmt.Errorf("absolute path")
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path escapes the destination folder")
+	}
+	if clean == "." && !e.mode.IsDir() {
+		return "", fmt.Errorf("empty file name")
+	}
+
+	// The folders of the entry, and the folder entry itself, are never written through a symlink, e.g. one
+	// extracted by a previous entry of the archive.
+	if link := symlinkInPath(dst, clean, e.mode.IsDir()); link != "" {
+		return "", fmt.Errorf("%s is a symlink, nothing is written through it", link)
+	}
+	target := filepath.Join(dst, filepath.FromSlash(clean))
+
+	if e.mode&os.ModeSymlink != 0 {
+		link := strings.ReplaceAll(e.link, `\`, "/")
+		if link == "" || path.IsAbs(link) || filepath.IsAbs(filepath.FromSlash(link)) || hasVolumeName(link) {
+			return "", fmt.Errorf("symlink to the absolute path %q", e.link)
+		}
+		if !linkInside(dst, filepath.Dir(target), link) {
+			return "", fmt.Errorf("symlink %q points outside of the destination folder", e.link)
+		}
+	}
+	return target, nil
+}
+
+// hasVolumeName checks if the slash separated name starts with a Windows drive letter, e.g. "C:".
+func hasVolumeName(name string) bool {
+	return len(name) >= 2 && name[1] == ':' && (name[0]|0x20 >= 'a' && name[0]|0x20 <= 'z')
+}
+
+// symlinkInPath returns the first existing symlink of the slash separated name's parent folders in the dst
+// folder, and of the name itself when "last" is true, or an empty string when there's none.
+func symlinkInPath(dst, name string, last bool) string {
+	parts := strings.Split(name, "/")
+	if !last {
+		parts = parts[:len(parts)-1]
+	}
+	p := dst
+	for i, part := range parts {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return "" // Nothing below a missing folder exists either.
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return path.Join(parts[:i+1]...)
+		}
+	}
+	return ""
+}
+
+// linkInside checks if the symlink target, relative to the dir folder, stays inside of the dst folder.
+// The target is resolved one name at a time the same as the OS does, so the existing symlinks it goes
+// through, e.g. "d/.." where "d" is a symlink, are followed before the "..".
+func linkInside(dst, dir, link string) bool {
+	cur := dir
+	for _, part := range strings.Split(link, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, part)
+			if fi, err := os.Lstat(cur); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				real, err := filepath.EvalSymlinks(cur)
+				if err != nil {
+					return false // A dangling symlink, where it points can't be checked.
+				}
+				cur = real
+			}
+		}
+		if !insideDir(dst, cur) {
+			return false
+		}
+	}
+	return true
+}
+
+// insideDir checks if the path is the dir folder or inside of it.
+func insideDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rejectEntry reports an archive entry that isn't extracted because it would be written outside of
+// the destination folder.
+func rejectEntry(name string, reason error) {
+	fmt.Println("rejected entry:", name, "-", reason)
+	Sugar.Errorw("rejected entry", "entry", name, "reason", reason.Error(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+}
+
+// writeExtractedFile writes the content of a file entry and keeps its permissions and modification time.
+func writeExtractedFile(target string, r io.Reader, e *archiveEntry) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	os.Remove(target) // It may be a symlink or hard-linked into a snapshot.
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, e.mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(target, e.mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, e.modTime, e.modTime)
+}
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+	extractCmd.Flags().StringVar(&extractInto, "into", "", "destination folder of the extracted files (default is beside the archive)")
+	extractCmd.Flags().StringVar(&extractOnConflict, "on-conflict", ConflictOverwrite, "what to do with the existing files: "+strings.Join(conflictPolicies, ", "))
+}
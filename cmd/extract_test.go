@@ -0,0 +1,536 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntry is an entry of a crafted test archive, a folder when its name ends with "/".
+type testEntry struct {
+	name    string
+	body    string
+	link    string // The symlink target, the entry is a symlink when it's set
+	modTime time.Time
+}
+
+// writeTestTarGz writes the entries into a new tar.gz archive, as they are, with no checks of their names.
+func writeTestTarGz(t *testing.T, file string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, ModTime: entryTime(e), Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.link != "":
+			h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			h.Typeflag, h.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestZip writes the entries into a new zip archive, a folder when its name ends with "/" or a backslash.
+// The symlinks aren't supported.
+func writeTestZip(t *testing.T, file string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: entryTime(e)}
+		h.SetMode(0644)
+		if strings.HasSuffix(e.name, "/") || strings.HasSuffix(e.name, `\`) {
+			h.SetMode(os.ModeDir | 0755)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entryTime returns the modification time of the test entry, a fixed one when it's not set.
+func entryTime(e testEntry) time.Time {
+	if e.modTime.IsZero() {
+		return time.Date(2020, time.June, 1, 8, 0, 0, 0, time.UTC)
+	}
+	return e.modTime
+}
+
+// listTree returns the slash separated paths in the folder, "name -> link" for the symlinks and "name/"
+// for the folders, or the file contents in the "files" map when it's not nil.
+func listTree(t *testing.T, dir string, files map[string]string) []string {
+	t.Helper()
+	var list []string
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, _ := os.Readlink(file)
+			list = append(list, rel+" -> "+filepath.ToSlash(link))
+		case fi.IsDir():
+			list = append(list, rel+"/")
+		default:
+			list = append(list, rel)
+			if files != nil {
+				data, _ := ioutil.ReadFile(file)
+				files[rel] = string(data)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// testTempDir returns a new temporary folder, its symlinks are resolved so the paths can be compared.
+func testTempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gokopy_extract_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestSafeEntryPath(t *testing.T) {
+	dst, cleanup := testTempDir(t)
+	defer cleanup()
+
+	// The existing "d" symlink points to the dst folder itself, and "sub" is a real folder.
+	if err := os.Mkdir(filepath.Join(dst, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(dst, "d")); err != nil {
+		t.Fatal(err)
+	}
+
+	file, dir := &archiveEntry{mode: 0644}, &archiveEntry{mode: os.ModeDir | 0755}
+	symlink := func(link string) *archiveEntry { return &archiveEntry{mode: os.ModeSymlink | 0777, link: link} }
+	tests := []struct {
+		name   string
+		entry  *archiveEntry
+		target string // Relative to the dst folder, empty when the entry is rejected
+		err    string
+	}{
+		{name: "a.txt", entry: file, target: "a.txt"},
+		{name: "sub/a.txt", entry: file, target: "sub/a.txt"},
+		{name: "sub/../a.txt", entry: file, target: "a.txt"},
+		{name: "./sub", entry: dir, target: "sub"},
+		{name: ".", entry: dir, target: "."},
+		{name: ".", entry: file, err: "empty file name"},
+		{name: "", entry: file, err: "absolute path"},
+		{name: "/etc/passwd", entry: file, err: "absolute path"},
+		{name: "C:/Windows/win.ini", entry: file, err: "absolute path"},
+		{name: "c:evil.txt", entry: file, err: "absolute path"},
+		{name: "..", entry: dir, err: "path escapes the destination folder"},
+		{name: "../evil.txt", entry: file, err: "path escapes the destination folder"},
+		{name: "sub/../../evil.txt", entry: file, err: "path escapes the destination folder"},
+		{name: "d/evil.txt", entry: file, err: "d is a symlink, nothing is written through it"},
+		{name: "d/sub/evil.txt", entry: file, err: "d is a symlink, nothing is written through it"},
+		{name: "d", entry: dir, err: "d is a symlink, nothing is written through it"},
+		{name: "d", entry: file, target: "d"},
+		{name: "link", entry: symlink("sub/a.txt"), target: "link"},
+		{name: "sub/link", entry: symlink("../a.txt"), target: "sub/link"},
+		{name: "link", entry: symlink("."), target: "link"},
+		{name: "link", entry: symlink(""), err: `symlink to the absolute path ""`},
+		{name: "link", entry: symlink("/etc"), err: `symlink to the absolute path "/etc"`},
+		{name: "link", entry: symlink(`C:\Windows`), err: `symlink to the absolute path "C:\\Windows"`},
+		{name: "link", entry: symlink(".."), err: `symlink ".." points outside of the destination folder`},
+		{name: "sub/link", entry: symlink("../../etc"), err: `symlink "../../etc" points outside of the destination folder`},
+		{name: "link", entry: symlink("sub/../../etc"), err: `symlink "sub/../../etc" points outside of the destination folder`},
+		// "d/sub/../.." is "." when it's cleaned, but "d" is the dst folder so it's the parent of dst.
+		{name: "link", entry: symlink("d/sub/../.."), err: `symlink "d/sub/../.." points outside of the destination folder`},
+		{name: "link", entry: symlink("d/sub/.."), target: "link"},
+	}
+	for _, tt := range tests {
+		target, err := safeEntryPath(dst, tt.name, tt.entry)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("safeEntryPath(%q, %v %q) error = %v, want %q", tt.name, tt.entry.mode, tt.entry.link, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("safeEntryPath(%q, %v %q) error = %v", tt.name, tt.entry.mode, tt.entry.link, err)
+			continue
+		}
+		if want := filepath.Join(dst, filepath.FromSlash(tt.target)); target != want {
+			t.Errorf("safeEntryPath(%q) = %q, want %q", tt.name, target, want)
+		}
+	}
+}
+
+func TestEntryMatcher(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		matches  map[string]bool
+	}{
+		{[]string{"reports/2020/budget.xlsx"}, map[string]bool{
+			"reports/2020/budget.xlsx": true, "reports/2020/budget.xls": false, "x/reports/2020/budget.xlsx": false}},
+		{[]string{"reports"}, map[string]bool{
+			"reports": true, "reports/2020/budget.xlsx": true, "reportsx/a": false, "x/reports": true}},
+		{[]string{"reports/2020"}, map[string]bool{
+			"reports/2020": true, "reports/2020/a.txt": true, "reports/2021/a.txt": false, "x/reports/2020": false}},
+		{[]string{"*.docx"}, map[string]bool{
+			"a.docx": true, "x/y/a.docx": true, "a.docx.bak": false, "docs.docx/a.txt": true}},
+		{[]string{"docs/*.pdf"}, map[string]bool{
+			"docs/a.pdf": true, "docs/x/a.pdf": false, "a.pdf": false}},
+		{[]string{"docs/?/*"}, map[string]bool{
+			"docs/a/b": true, "docs/a/b/c": true, "docs/ab/c": false}},
+		{[]string{"[ab]*.txt"}, map[string]bool{"a1.txt": true, "c1.txt": false, "x/b.txt": true}},
+		{[]string{`\reports\2020\`, "/a.txt", "./b/../c.txt"}, map[string]bool{
+			"reports/2020/x": true, "a.txt": true, "c.txt": true, "b/c.txt": true, "b.txt": false}},
+		{[]string{"../outside"}, map[string]bool{"outside": true, "x/outside": true, "outsider": false}},
+	}
+	for _, tt := range tests {
+		match, err := entryMatcher(tt.patterns)
+		if err != nil {
+			t.Fatalf("entryMatcher(%q) error = %v", tt.patterns, err)
+		}
+		for name, want := range tt.matches {
+			if got := match(name); got != want {
+				t.Errorf("entryMatcher(%q)(%q) = %v, want %v", tt.patterns, name, got, want)
+			}
+		}
+	}
+
+	for _, patterns := range [][]string{{""}, {"/"}, {"a", "."}, {"[a"}} {
+		if _, err := entryMatcher(patterns); err == nil {
+			t.Errorf("entryMatcher(%q) error = nil", patterns)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	older := time.Date(2020, time.June, 1, 8, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		name    string
+		policy  string
+		existed time.Time // The modification time of the existing a.txt file
+		entry   time.Time
+		target  string
+		skip    bool
+	}{
+		{name: "overwrite", policy: ConflictOverwrite, existed: newer, entry: older, target: "a.txt"},
+		{name: "default policy", policy: "", existed: newer, entry: older, target: "a.txt"},
+		{name: "skip", policy: ConflictSkip, existed: older, entry: newer, target: "a.txt", skip: true},
+		{name: "newer archived file", policy: ConflictNewer, existed: older, entry: newer, target: "a.txt"},
+		{name: "older archived file", policy: ConflictNewer, existed: newer, entry: older, target: "a.txt", skip: true},
+		{name: "same time", policy: ConflictNewer, existed: older, entry: older, target: "a.txt", skip: true},
+		{name: "rename", policy: ConflictRename, existed: older, entry: newer, target: "a_2.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, cleanup := testTempDir(t)
+			defer cleanup()
+			for _, name := range []string{"a.txt", "a_1.txt"} {
+				if err := ioutil.WriteFile(filepath.Join(dst, name), []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Chtimes(filepath.Join(dst, "a.txt"), tt.existed, tt.existed); err != nil {
+				t.Fatal(err)
+			}
+
+			target, skip := resolveConflict(filepath.Join(dst, "a.txt"), &archiveEntry{mode: 0644, modTime: tt.entry}, tt.policy)
+			if want := filepath.Join(dst, tt.target); target != want || skip != tt.skip {
+				t.Errorf("resolveConflict() = %q, %v, want %q, %v", target, skip, want, tt.skip)
+			}
+		})
+	}
+
+	dst, cleanup := testTempDir(t)
+	defer cleanup()
+	if err := os.Mkdir(filepath.Join(dst, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range conflictPolicies {
+		target := filepath.Join(dst, "sub")
+		got, skip := resolveConflict(target, &archiveEntry{mode: 0644}, policy)
+		if policy == ConflictRename {
+			if want := filepath.Join(dst, "sub_1"); got != want || skip {
+				t.Errorf("%s of a folder = %q, %v, want %q, false", policy, got, skip, want)
+			}
+		} else if !skip {
+			t.Errorf("%s replaces a folder by a file", policy)
+		}
+		if got, skip := resolveConflict(filepath.Join(dst, "new.txt"), &archiveEntry{mode: 0644}, policy); skip || got != filepath.Join(dst, "new.txt") {
+			t.Errorf("%s of a new file = %q, %v", policy, got, skip)
+		}
+	}
+}
+
+// maliciousEntries are the entries of a crafted archive, both the safe and the rejected ones.
+var maliciousEntries = []testEntry{
+	{name: "docs/"},
+	{name: "docs/a.txt", body: "archived a"},
+	{name: "docs/sub/"},
+	{name: "docs/sub/b.txt", body: "archived b"},
+	{name: "/abs.txt", body: "absolute"},
+	{name: "../evil.txt", body: "dot dot"},
+	{name: "docs/../../evil.txt", body: "dot dot"},
+	{name: "docs/up", link: "../../outside"},
+	{name: "docs/etc", link: "/etc"},
+	{name: "docs/b", link: "sub/b.txt"},
+	// "here" is the archived folder itself, so "here/sub/../.." is its parent.
+	{name: "docs/here", link: "."},
+	{name: "docs/escape", link: "here/sub/../.."},
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []testEntry
+		zip      bool
+		opts     extractOptions
+		want     []string
+		rejected int
+	}{
+		{
+			name:    "strip the root folder",
+			entries: maliciousEntries,
+			opts:    extractOptions{stripRoot: true},
+			want:    []string{"a.txt", "b -> sub/b.txt", "here -> .", "sub/", "sub/b.txt"},
+			// "/abs.txt", "../evil.txt", "docs/../../evil.txt", "docs/up", "docs/etc" and "docs/escape"
+			rejected: 6,
+		},
+		{
+			name:    "keep the root folder",
+			entries: maliciousEntries,
+			opts:    extractOptions{},
+			// "docs/escape" points to the dst folder itself when the root folder is kept.
+			want:     []string{"docs/", "docs/a.txt", "docs/b -> sub/b.txt", "docs/escape -> here/sub/../..", "docs/here -> .", "docs/sub/", "docs/sub/b.txt"},
+			rejected: 5,
+		},
+		{
+			name:     "matching entries only",
+			entries:  maliciousEntries,
+			opts:     extractOptions{stripRoot: true, match: func(name string) bool { return strings.HasPrefix(name, "sub") || name == "up" }},
+			want:     []string{"sub/", "sub/b.txt"},
+			rejected: 1,
+		},
+		{
+			name:    "zip with backslashes",
+			zip:     true,
+			entries: []testEntry{{name: `docs\`}, {name: `docs\sub\b.txt`, body: "b"}, {name: `..\evil.txt`, body: "evil"}, {name: `C:\evil.txt`, body: "evil"}},
+			opts:    extractOptions{stripRoot: true},
+			want:    []string{"sub/", "sub/b.txt"},
+			// "..\evil.txt" and "C:\evil.txt"
+			rejected: 2,
+		},
+		{
+			// "b" is created after "l" and makes it point to the parent folder of dst.
+			name:     "symlink changed by a later one",
+			entries:  []testEntry{{name: "l", link: "b/../escaped"}, {name: "b", link: "."}},
+			opts:     extractOptions{},
+			want:     []string{"b -> ."},
+			rejected: 1,
+		},
+		{
+			name: "manifest isn't extracted",
+			entries: []testEntry{{name: "docs/" + ManifestName, body: "{}"}, {name: ManifestName, body: "{}"}, {name: "docs/"}, {name: "docs/a.txt", body: "a"},
+				{name: "docs/sub/" + ManifestName, body: "{}"}},
+			opts: extractOptions{stripRoot: true},
+			// Only the manifest of the archived folder is left out, the sub-folders are never given one.
+			want: []string{"a.txt", "sub/", "sub/" + ManifestName},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := testTempDir(t)
+			defer cleanup()
+			src, dst := filepath.Join(dir, "test.tar.gz"), filepath.Join(dir, "restore", "docs")
+			if tt.zip {
+				src = filepath.Join(dir, "test.zip")
+				writeTestZip(t, src, tt.entries)
+			} else {
+				writeTestTarGz(t, src, tt.entries)
+			}
+
+			st, _, err := extractArchive(src, dst, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listTree(t, dst, nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extracted = %q, want %q", got, tt.want)
+			}
+			if st.rejected != tt.rejected {
+				t.Errorf("rejected = %d, want %d", st.rejected, tt.rejected)
+			}
+			// Nothing is written outside of the dst folder.
+			if got := listTree(t, dir, nil); len(got) != len(tt.want)+3 {
+				t.Errorf("the test folder has %q", got)
+			}
+		})
+	}
+}
+
+func TestExtractOnConflict(t *testing.T) {
+	older := time.Date(2020, time.June, 1, 8, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	entries := []testEntry{
+		{name: "a.txt", body: "archived a", modTime: newer},
+		{name: "b.txt", body: "archived b", modTime: older},
+		{name: "c.txt", body: "archived c"},
+		{name: "sub", body: "a file over a folder"},
+		{name: "link", link: "a.txt"},
+	}
+	tests := []struct {
+		policy  string
+		files   map[string]string
+		skipped int
+	}{
+		{ConflictOverwrite, map[string]string{"a.txt": "archived a", "b.txt": "archived b", "c.txt": "archived c"}, 1},
+		{ConflictSkip, map[string]string{"a.txt": "existing a", "b.txt": "existing b", "c.txt": "archived c"}, 3},
+		{ConflictNewer, map[string]string{"a.txt": "archived a", "b.txt": "existing b", "c.txt": "archived c"}, 2},
+		{ConflictRename, map[string]string{"a.txt": "existing a", "a_1.txt": "archived a", "b.txt": "existing b", "b_1.txt": "archived b",
+			"c.txt": "archived c", "sub_1": "a file over a folder"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			dir, cleanup := testTempDir(t)
+			defer cleanup()
+			src, dst := filepath.Join(dir, "test.tar.gz"), filepath.Join(dir, "restore")
+			writeTestTarGz(t, src, entries)
+
+			// The existing files are a day apart from the archived ones, "sub" is a folder.
+			if err := os.MkdirAll(filepath.Join(dst, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, mt := range map[string]time.Time{"a.txt": older.Add(-24 * time.Hour), "b.txt": newer.Add(24 * time.Hour)} {
+				file := filepath.Join(dst, name)
+				if err := ioutil.WriteFile(file, []byte("existing "+name[:1]), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(file, mt, mt); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The same as the dcfile command with the --on-conflict flag.
+			st, _, err := extractArchive(src, dst, extractOptions{onConflict: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make(map[string]string)
+			listTree(t, dst, files)
+			if !reflect.DeepEqual(files, tt.files) {
+				t.Errorf("files = %q, want %q", files, tt.files)
+			}
+			if st.skipped != tt.skipped {
+				t.Errorf("skipped = %d, want %d", st.skipped, tt.skipped)
+			}
+			if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "a.txt" {
+				t.Errorf("link = %q, %v", link, err)
+			}
+		})
+	}
+}
+
+func TestDcdirInto(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	src := filepath.Join(dir, "docs.tar.gz")
+	writeTestTarGz(t, src, []testEntry{{name: "docs/"}, {name: "docs/a.txt", body: "a"}})
+	defer func() { dcdirInto = "" }()
+
+	tests := []struct {
+		name string
+		args []string
+		into string
+		want string // The folder the files are extracted into, empty when nothing is extracted
+	}{
+		{name: "beside the archive", args: []string{src}, want: "docs"},
+		{name: "second argument", args: []string{src, filepath.Join(dir, "arg")}, want: "arg"},
+		{name: "into flag", args: []string{src}, into: filepath.Join(dir, "into", "deep"), want: "into/deep"},
+		{name: "same folder twice", args: []string{src, filepath.Join(dir, "same")}, into: filepath.Join(dir, "same") + "/", want: "same"},
+		{name: "other folders", args: []string{src, filepath.Join(dir, "one")}, into: filepath.Join(dir, "two")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dcdirInto = tt.into
+			dcdirCmd.Run(dcdirCmd, tt.args)
+
+			var extracted []string
+			for _, name := range listTree(t, dir, nil) {
+				if strings.HasSuffix(name, "/a.txt") {
+					extracted = append(extracted, strings.TrimSuffix(name, "/a.txt"))
+				}
+			}
+			var want []string
+			if tt.want != "" {
+				want = []string{tt.want}
+			}
+			if !reflect.DeepEqual(extracted, want) {
+				t.Errorf("extracted into %q, want %q", extracted, want)
+			}
+			for _, name := range extracted {
+				os.RemoveAll(filepath.Join(dir, filepath.FromSlash(strings.Split(name, "/")[0])))
+			}
+		})
+	}
+}
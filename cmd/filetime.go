@@ -0,0 +1,75 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The file times of the copymd --time-field flag.
+const (
+	TimeFieldMtime = "mtime" // Last modification of the contents
+	TimeFieldCtime = "ctime" // Last change of the contents or the metadata, e.g. the permissions or a rename
+	TimeFieldBtime = "btime" // Creation of the file, the birth time
+)
+
+// timeFields are the valid values of the copymd --time-field flag.
+var timeFields = []string{TimeFieldMtime, TimeFieldCtime, TimeFieldBtime}
+
+// errTimeFieldUnsupported is returned when the OS or the file system doesn't keep the file time.
+var errTimeFieldUnsupported = errors.New("the file time isn't supported on this OS or file system")
+
+// fileTime returns the time field of the file, its info is from os.Stat.
+func fileTime(file string, fi os.FileInfo, field string) (time.Time, error) {
+	var t time.Time
+	var err error
+	switch field {
+	case TimeFieldMtime, "":
+		return fi.ModTime(), nil
+	case TimeFieldCtime:
+		t, err = changeTime(fi)
+	case TimeFieldBtime:
+		t, err = birthTime(file, fi)
+	default:
+		return t, fmt.Errorf("invalid time field %q, it must be one of: %s", field, strings.Join(timeFields, ", "))
+	}
+	if err != nil {
+		return t, fmt.Errorf("%s of %s: %v", field, file, err)
+	}
+	return t, nil
+}
+
+// parseTimeArg parses the copymd --since and --until flags, either an absolute date, e.g. 2020-06-01 or
+// RFC3339, or a duration before now, e.g. 36h, 90m or 7d.
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if t, err := parseDate(s); err == nil {
+		return t, nil
+	}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "-")
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date or a duration, e.g. 2020-06-01T08:00:00Z, 36h or 7d", s)
+}
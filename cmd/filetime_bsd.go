@@ -0,0 +1,44 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the ctime of the file, the last change of its contents or its metadata.
+func changeTime(fi os.FileInfo) (time.Time, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, errTimeFieldUnsupported
+	}
+	return time.Unix(st.Ctimespec.Unix()), nil
+}
+
+// birthTime returns the creation time of the file.
+func birthTime(file string, fi os.FileInfo) (time.Time, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, errTimeFieldUnsupported
+	}
+	return time.Unix(st.Birthtimespec.Unix()), nil
+}
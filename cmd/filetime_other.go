@@ -0,0 +1,35 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!windows
+
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime isn't supported on this OS.
+func changeTime(fi os.FileInfo) (time.Time, error) {
+	return time.Time{}, errTimeFieldUnsupported
+}
+
+// birthTime isn't supported on this OS.
+func birthTime(file string, fi os.FileInfo) (time.Time, error) {
+	return time.Time{}, errTimeFieldUnsupported
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// filterFlags is the --min-size, --max-size, --newer-than, --older-than and --only-ext flags of the
+// copydir, copymd and comdir commands, as they're typed.
+type filterFlags struct {
+	minSize   string
+	maxSize   string
+	newerThan string
+	olderThan string
+	onlyExt   []string
+}
+
+// addFilterFlags registers the file filter flags of the copydir, copymd and comdir commands.
+func addFilterFlags(cmd *cobra.Command, f *filterFlags) {
+	cmd.Flags().StringVar(&f.minSize, "min-size", "", "only the files of at least this size, e.g. 500, 10KB, 1.5GB")
+	cmd.Flags().StringVar(&f.maxSize, "max-size", "", "only the files of at most this size, e.g. 4GB")
+	cmd.Flags().StringVar(&f.newerThan, "newer-than", "", `only the files modified since this date, e.g. 2020-06-01, "2020-06-01 08:00" or RFC3339`)
+	cmd.Flags().StringVar(&f.olderThan, "older-than", "", "only the files modified before this date")
+	cmd.Flags().StringSliceVar(&f.onlyExt, "only-ext", nil, "only the files with these extensions, e.g. .log,.txt")
+}
+
+// fileFilter selects the files to back up by their size, modification time and extension, on top of
+// the ignore list. The folders are always walked.
+type fileFilter struct {
+	minSize   int64     // 0 has no minimum
+	maxSize   int64     // -1 has no maximum
+	newerThan time.Time // Zero has no lower bound
+	olderThan time.Time // Zero has no upper bound
+	onlyExt   []string  // Lower case with the leading "."
+	filtered  int       // Files left out by the filter
+}
+
+// parseFilter parses the filter flags, it returns nil when there's no filter at all.
+func parseFilter(f filterFlags) (*fileFilter, error) {
+	if f.minSize == "" && f.maxSize == "" && f.newerThan == "" && f.olderThan == "" && len(f.onlyExt) == 0 {
+		return nil, nil
+	}
+
+	ff := &fileFilter{maxSize: -1}
+	var err error
+	if f.minSize != "" {
+		if ff.minSize, err = parseSize(f.minSize); err != nil {
+			return nil, fmt.Errorf("invalid --min-size: %v", err)
+		}
+	}
+	if f.maxSize != "" {
+		if ff.maxSize, err = parseSize(f.maxSize); err != nil {
+			return nil, fmt.Errorf("invalid --max-size: %v", err)
+		}
+	}
+	if f.newerThan != "" {
+		if ff.newerThan, err = parseDate(f.newerThan); err != nil {
+			return nil, fmt.Errorf("invalid --newer-than: %v", err)
+		}
+	}
+	if f.olderThan != "" {
+		if ff.olderThan, err = parseDate(f.olderThan); err != nil {
+			return nil, fmt.Errorf("invalid --older-than: %v", err)
+		}
+	}
+	if ff.maxSize >= 0 && ff.minSize > ff.maxSize {
+		return nil, fmt.Errorf("the --min-size %s must not be more than the --max-size %s", f.minSize, f.maxSize)
+	}
+	if !ff.newerThan.IsZero() && !ff.olderThan.IsZero() && !ff.newerThan.Before(ff.olderThan) {
+		return nil, fmt.Errorf("the --newer-than date %s must be before the --older-than date %s", f.newerThan, f.olderThan)
+	}
+	for _, ext := range f.onlyExt {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			ff.onlyExt = append(ff.onlyExt, "."+strings.TrimPrefix(ext, "."))
+		}
+	}
+	return ff, nil
+}
+
+// keep checks if the file passes the filter, the files left out are counted. A nil filter keeps
+// every file.
+func (f *fileFilter) keep(name string, fi os.FileInfo) bool {
+	if f == nil || f.match(name, fi) {
+		return true
+	}
+	f.filtered++
+	return false
+}
+
+func (f *fileFilter) match(name string, fi os.FileInfo) bool {
+	if fi.Size() < f.minSize || (f.maxSize >= 0 && fi.Size() > f.maxSize) {
+		return false
+	}
+	if !f.newerThan.IsZero() && fi.ModTime().Before(f.newerThan) {
+		return false
+	}
+	if !f.olderThan.IsZero() && !fi.ModTime().Before(f.olderThan) {
+		return false
+	}
+	if len(f.onlyExt) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, ext := range f.onlyExt {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredCount returns the number of files left out by the filter.
+func (f *fileFilter) filteredCount() int {
+	if f == nil {
+		return 0
+	}
+	return f.filtered
+}
+
+// sizeUnits are the 1024-based units of parseSize.
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseSize parses a number of bytes with an optional unit, e.g. 500, 10KB or 1.5GB.
+func parseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("%q isn't a size, e.g. 500, 10KB or 1.5GB", s)
+	}
+	return int64(n * unit), nil
+}
+
+// dateFormats are the absolute dates of parseDate, in the local time zone unless it's given.
+var dateFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseDate parses an absolute date, e.g. 2020-06-01, "2020-06-01 08:00" or 2020-06-01T08:00:00Z.
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date, e.g. 2020-06-01, \"2020-06-01 08:00\" or RFC3339", s)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   filterFlags
+		wantNil bool
+		wantErr string
+	}{
+		{name: "no filter", flags: filterFlags{}, wantNil: true},
+		{name: "sizes", flags: filterFlags{minSize: "1KB", maxSize: "1MB"}},
+		{name: "same size", flags: filterFlags{minSize: "1KB", maxSize: "1024"}},
+		{name: "only a minimum size", flags: filterFlags{minSize: "1GB"}},
+		{name: "dates", flags: filterFlags{newerThan: "2020-06-01", olderThan: "2020-06-02"}},
+		{name: "only extensions", flags: filterFlags{onlyExt: []string{".log", "TXT"}}},
+		{name: "invalid size", flags: filterFlags{maxSize: "big"}, wantErr: "invalid --max-size"},
+		{name: "invalid date", flags: filterFlags{newerThan: "yesterday"}, wantErr: "invalid --newer-than"},
+		{name: "min size over max size", flags: filterFlags{minSize: "2MB", maxSize: "1MB"}, wantErr: "--min-size 2MB must not be more than the --max-size 1MB"},
+		{name: "newer than after older than", flags: filterFlags{newerThan: "2020-06-02", olderThan: "2020-06-01"},
+			wantErr: "--newer-than date 2020-06-02 must be before the --older-than date 2020-06-01"},
+		{name: "same dates", flags: filterFlags{newerThan: "2020-06-01", olderThan: "2020-06-01"}, wantErr: "must be before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ff, err := parseFilter(tt.flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ff == nil) != tt.wantNil {
+				t.Errorf("filter = %+v, want nil %v", ff, tt.wantNil)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+)
+
+// IgnoreFileName is the name of the per-folder ignore files, their patterns apply to the folder they're in
+// and its sub-folders, the same as the .gitignore files.
+const IgnoreFileName = ".gokopyignore"
+
+// ignorePattern is a single gitignore-style pattern compiled to a regular expression.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool   // "!pattern" includes back what a previous pattern ignored
+	dirOnly bool   // "pattern/" only matches the folders
+	base    string // Slash separated folder of the .gokopyignore file, relative to the walked root
+}
+
+// ignoreMatcher checks the paths of a walked folder against the ignore patterns of the 'config.yaml'
+// file and the .gokopyignore files of the folder and its sub-folders. The last matching pattern wins,
+// the deeper .gokopyignore files come after the upper ones and the 'config.yaml' patterns come first.
+type ignoreMatcher struct {
+	root     string
+	patterns []ignorePattern
+	perDir   map[string][]ignorePattern // The patterns of the .gokopyignore file by its folder
+}
+
+// newIgnoreMatcher returns the matcher of the paths in the root folder, the patterns are usually the
+// IgnoreList of the 'config.yaml' file.
+func newIgnoreMatcher(root string, patterns []string) *ignoreMatcher {
+	m := &ignoreMatcher{root: root, perDir: map[string][]ignorePattern{}}
+	for _, line := range patterns {
+		if p, ok := compileIgnorePattern(line, ""); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return m
+}
+
+// match checks if the path in the root folder is ignored, the walkers skip the whole ignored folders
+// so a file can't be included back when its parent folder is ignored.
+func (m *ignoreMatcher) match(file string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel, err := filepath.Rel(m.root, file)
+	if err != nil || rel == "." {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	ignored := false
+	check := func(patterns []ignorePattern) {
+		for _, p := range patterns {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			name := rel
+			if p.base != "" {
+				name = strings.TrimPrefix(rel, p.base+"/")
+			}
+			if p.re.MatchString(name) {
+				ignored = !p.negate
+			}
+		}
+	}
+	check(m.patterns)
+
+	// The .gokopyignore files of the root folder down to the parent folder of the path.
+	dir := ""
+	check(m.dirPatterns(dir))
+	for _, part := range strings.Split(path.Dir(rel), "/") {
+		if part == "." {
+			break
+		}
+		dir = path.Join(dir, part)
+		check(m.dirPatterns(dir))
+	}
+	return ignored
+}
+
+// dirPatterns returns the patterns of the .gokopyignore file of the folder, it's only read once.
+func (m *ignoreMatcher) dirPatterns(dir string) []ignorePattern {
+	if patterns, ok := m.perDir[dir]; ok {
+		return patterns
+	}
+
+	var patterns []ignorePattern
+	if f, err := os.Open(filepath.Join(m.root, filepath.FromSlash(dir), IgnoreFileName)); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if p, ok := compileIgnorePattern(s.Text(), dir); ok {
+				patterns = append(patterns, p)
+			}
+		}
+		f.Close()
+	}
+	m.perDir[dir] = patterns
+	return patterns
+}
+
+// compileIgnorePattern compiles a gitignore-style pattern of the base folder, it returns false for the
+// blank lines, the comments and the invalid patterns.
+//
+//	*.log           the files or folders named *.log in any sub-folder
+//	/build          only the build folder or file of the base folder
+//	docs/*.pdf      a pattern with a "/" is relative to the base folder
+//	**/cache/       the cache folders in any sub-folder, the trailing "/" only matches the folders
+//	logs/**         everything inside of the logs folder
+//	a/**/b          a/b, a/x/b, a/x/y/b and so on, any other "**" is the same as "*"
+//	!keep.log       includes back the files that a previous pattern ignored
+func compileIgnorePattern(line, base string) (ignorePattern, bool) {
+	p := ignorePattern{base: base}
+	line = strings.TrimSuffix(line, "\r")
+	trimmed := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		trimmed += line[len(trimmed) : len(trimmed)+1] // The trailing space quoted with a backslash is kept.
+	}
+	line = trimmed
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+
+	// A pattern without any "/" but a trailing one matches the names in any sub-folder.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "/**") && i+3 == len(line):
+			re.WriteString("/.*")
+			i += 2
+		case line == "**":
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		Sugar.Errorw("invalid ignore pattern", "pattern", line, "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return p, false
+	}
+	p.re = compiled
+	return p, true
+}
+
+// legacyIgnorePattern converts an item of the comma separated file_type_or_folder_name list into its
+// pattern, a file extension such as ".db" becomes "*.db" so it keeps matching every .db file.
+func legacyIgnorePattern(item string) string {
+	if strings.HasPrefix(item, ".") && !strings.ContainsAny(item, "/*?[!") {
+		return "*" + item
+	}
+	return item
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileIgnorePattern(t *testing.T) {
+	type match struct {
+		path  string
+		isDir bool
+		want  bool
+	}
+	tests := []struct {
+		pattern string
+		matches []match
+	}{
+		{"*.log", []match{{"a.log", false, true}, {"x/y/a.log", false, true}, {"a.log", true, true}, {"a.log.txt", false, false}, {"alog", false, false}}},
+		{"build", []match{{"build", true, true}, {"build", false, true}, {"src/build", true, true}, {"builds", true, false}, {"build/x", false, false}}},
+		{"/build", []match{{"build", true, true}, {"src/build", true, false}}},
+		{"docs/*.pdf", []match{{"docs/a.pdf", false, true}, {"docs/x/a.pdf", false, false}, {"x/docs/a.pdf", false, false}}},
+		{"cache/", []match{{"cache", true, true}, {"a/b/cache", true, true}, {"cache", false, false}, {"a/cache", false, false}}},
+		{"doc/frotz/", []match{{"doc/frotz", true, true}, {"a/doc/frotz", true, false}, {"doc/frotz", false, false}}},
+		{"**/cache/", []match{{"cache", true, true}, {"a/b/cache", true, true}, {"cache", false, false}}},
+		{"**/foo/bar", []match{{"foo/bar", false, true}, {"a/b/foo/bar", false, true}, {"a/foo/bar/x", false, false}}},
+		{"logs/**", []match{{"logs/a", false, true}, {"logs/a/b.txt", false, true}, {"logs", true, false}, {"x/logs/a", false, false}}},
+		{"a/**/b", []match{{"a/b", false, true}, {"a/x/b", false, true}, {"a/x/y/b", false, true}, {"a/xb", false, false}, {"x/a/b", false, false}}},
+		{"a**b", []match{{"ab", false, true}, {"axyb", false, true}, {"a/b", false, false}, {"x/axb", false, true}}},
+		{"**", []match{{"a", false, true}, {"a/b/c", true, true}}},
+		{"foo/*", []match{{"foo/a", false, true}, {"foo/a", true, true}, {"foo/a/b", false, false}, {"foo", true, false}}},
+		{"?.txt", []match{{"a.txt", false, true}, {"ab.txt", false, false}, {"x/b.txt", false, true}}},
+		{"[abc].txt", []match{{"a.txt", false, true}, {"d.txt", false, false}}},
+		{"[!abc].txt", []match{{"a.txt", false, false}, {"d.txt", false, true}}},
+		{"[a-c]*.go", []match{{"b_test.go", false, true}, {"main.go", false, false}}},
+		{`\#file`, []match{{"#file", false, true}}},
+		{`\!important`, []match{{"!important", false, true}, {"important", false, false}}},
+		{`a\*b`, []match{{"a*b", false, true}, {"axb", false, false}}},
+		{"trailing   ", []match{{"trailing", false, true}, {"trailing ", false, false}}},
+		{`space\ `, []match{{"space ", false, true}, {"space", false, false}}},
+		{"file.txt\r", []match{{"file.txt", false, true}}},
+		{"a.b+c(d)", []match{{"a.b+c(d)", false, true}, {"axb+c(d)", false, false}}},
+	}
+	for _, tt := range tests {
+		p, ok := compileIgnorePattern(tt.pattern, "")
+		if !ok {
+			t.Errorf("pattern %q isn't compiled", tt.pattern)
+			continue
+		}
+		for _, m := range tt.matches {
+			got := (!p.dirOnly || m.isDir) && p.re.MatchString(m.path)
+			if got != m.want {
+				t.Errorf("pattern %q matches %q (folder: %v) = %v, want %v", tt.pattern, m.path, m.isDir, got, m.want)
+			}
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/", "!", "!/"} {
+		if _, ok := compileIgnorePattern(line, ""); ok {
+			t.Errorf("pattern %q is compiled, want it skipped", line)
+		}
+	}
+	if p, _ := compileIgnorePattern("!keep.log", ""); !p.negate {
+		t.Errorf("pattern !keep.log isn't negated")
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "gokopy_ignore_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ignoreFiles := map[string]string{
+		IgnoreFileName:                     "# root folder\n*.tmp\n!keep.tmp\n/dist/\n",
+		"src/" + IgnoreFileName:            "/gen\n*.bak\n!*.tmp\n",
+		"src/vendor/lib/" + IgnoreFileName: "!*.bak\n*.go\n",
+	}
+	for name, data := range ignoreFiles {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := newIgnoreMatcher(root, []string{"*.log", "node_modules/", "!important.log", "/secret.txt"})
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// The 'config.yaml' patterns.
+		{"a.log", false, true},
+		{"x/y/a.log", false, true},
+		{"x/important.log", false, false},
+		{"node_modules", true, true},
+		{"web/node_modules", true, true},
+		{"node_modules", false, false},
+		{"secret.txt", false, true},
+		{"x/secret.txt", false, false},
+
+		// The root .gokopyignore file.
+		{"a.tmp", false, true},
+		{"x/a.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"dist", true, true},
+		{"dist", false, false},
+		{"x/dist", true, false},
+
+		// The src/.gokopyignore file is anchored to the src folder and overrides the root one.
+		{"src/gen", true, true},
+		{"src/x/gen", true, false},
+		{"gen", true, false},
+		{"src/a.bak", false, true},
+		{"a.bak", false, false},
+		{"src/a.tmp", false, false},
+		{"src/x/a.tmp", false, false},
+		{"src", true, false},
+
+		// The deepest .gokopyignore file comes last.
+		{"src/vendor/lib/a.bak", false, false},
+		{"src/vendor/lib/x/a.bak", false, false},
+		{"src/vendor/a.bak", false, true},
+		{"src/vendor/lib/a.go", false, true},
+		{"src/a.go", false, false},
+
+		// The root folder itself is never ignored.
+		{"", true, false},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := m.match(file, tt.isDir); got != tt.want {
+			t.Errorf("match(%q, folder: %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	if (*ignoreMatcher)(nil).match(filepath.Join(root, "a.log"), false) {
+		t.Errorf("a nil matcher ignores the files")
+	}
+}
+
+func TestLegacyIgnorePattern(t *testing.T) {
+	tests := map[string]string{
+		".db":         "*.db",
+		"setup.exe":   "setup.exe",
+		"folder_name": "folder_name",
+		".git/":       ".git/",
+		".*.swp":      ".*.swp",
+		"*.log":       "*.log",
+	}
+	for item, want := range tests {
+		if got := legacyIgnorePattern(item); got != want {
+			t.Errorf("legacyIgnorePattern(%q) = %q, want %q", item, got, want)
+		}
+	}
+}
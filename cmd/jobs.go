@@ -0,0 +1,333 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Job types of the structured jobs in the 'config.yaml' file.
+const (
+	JobCopyDIR = "copydir"
+	JobCopyMD  = "copymd"
+)
+
+// jobKeys lists the allowed keys of a structured job, the "schedule" mapping has its own jobScheduleKeys.
+var jobKeys = []string{"name", "type", "src", "dst", "schedule", "retention", "modified_days", "ignore", "compress", "snapshot"}
+
+// jobScheduleKeys lists the allowed keys of a structured job's "schedule" mapping.
+var jobScheduleKeys = []string{"run_every", "interval", "run_at"}
+
+// jobNameFormat restricts the job name so it's safe to be used as a folder name.
+var jobNameFormat = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// jobSection returns the backup section of a structured job, which depends on its type and schedule interval.
+func jobSection(jobType, interval string) string {
+	_, frequent := frequentUnits[strings.ToLower(interval)]
+	switch {
+	case jobType == JobCopyDIR && frequent:
+		return SecCopyDIRF
+	case jobType == JobCopyDIR:
+		return SecCopyDIRD
+	case jobType == JobCopyMD && frequent:
+		return SecCopyMDF
+	default:
+		return SecCopyMD
+	}
+}
+
+// parseJobs parses and validates the structured jobs of the 'config.yaml' file, e.g.
+//
+//	jobs:
+//	  - name: documents
+//	    type: copydir
+//	    src: C:\a
+//	    dst: D:\backup
+//	    schedule: {run_every: 1, interval: days, run_at: "23:30"}
+//	    retention: -30
+//	    ignore: [.db, setup.exe]
+//	    compress: true
+//	    snapshot: true
+func parseJobs(file string, doc *yaml.Node) ([]backupItem, ConfigErrors) {
+	jobs := mappingValue(doc, "jobs")
+	if jobs == nil || isEmptyNode(jobs) {
+		return nil, nil
+	}
+
+	p := &backupItemParser{file: file, where: "jobs", aliases: map[string]string{"retention_days": "retention"}}
+	if jobs.Kind != yaml.SequenceNode {
+		p.errorf(jobs.Line, "jobs must be a list of job mappings")
+		return nil, p.errs
+	}
+
+	var items []backupItem
+	names := make(map[string]bool)
+	backups := mappingValue(doc, "backups")
+	for n, node := range jobs.Content {
+		if isEmptyNode(node) {
+			continue
+		}
+		p.where = fmt.Sprintf("jobs[%d]", n)
+		if node.Kind != yaml.MappingNode {
+			p.errorf(node.Line, "job must be a mapping of %s", strings.Join(jobKeys, ", "))
+			continue
+		}
+
+		if name := mappingValue(node, "name"); name != nil && name.Value != "" {
+			p.where = fmt.Sprintf("jobs[%d] (%s)", n, name.Value)
+		}
+
+		nErrs := len(p.errs)
+		item := backupItem{kv: make(map[string]string), snapshot: viper.GetBool("default.snapshot")}
+		var schedule *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			switch key {
+			case "name", "type", "src", "dst":
+				item.kv[key] = strings.TrimSpace(value.Value)
+			case "retention":
+				item.kv["retention_days"] = strings.TrimSpace(value.Value)
+			case "modified_days":
+				item.kv[key] = strings.TrimSpace(value.Value)
+			case "schedule":
+				schedule = value
+			case "ignore":
+				item.ignore = scalarList(value)
+			case "compress", "snapshot":
+				b, err := strconv.ParseBool(value.Value)
+				if err != nil {
+					p.errorf(value.Line, "%s=%s must be true or false", key, value.Value)
+				}
+				if key == "compress" {
+					item.compress = b
+				} else {
+					item.snapshot = b
+				}
+			default:
+				p.errorf(node.Content[i].Line, "unknown key %q, allowed keys are: %s", key, strings.Join(jobKeys, ", "))
+			}
+		}
+
+		item.name = item.kv["name"]
+		switch {
+		case item.name == "":
+			p.errorf(node.Line, "missing required key \"name\"")
+		case !jobNameFormat.MatchString(item.name):
+			p.errorf(node.Line, "name=%s must only contain letters, digits, '.', '_' or '-'", item.name)
+		case names[item.name]:
+			p.errorf(node.Line, "duplicate job name %q", item.name)
+		}
+		names[item.name] = true
+
+		jobType := item.kv["type"]
+		if jobType != JobCopyDIR && jobType != JobCopyMD {
+			p.errorf(node.Line, "type=%s must be either %s or %s", jobType, JobCopyDIR, JobCopyMD)
+			continue
+		}
+		if jobType == JobCopyMD && item.kv["retention_days"] != "" {
+			p.errorf(node.Line, "retention is only supported by the %s jobs", JobCopyDIR)
+			delete(item.kv, "retention_days")
+		}
+		if jobType == JobCopyDIR && item.kv["retention_days"] != "" && !item.snapshot {
+			// Without the snapshots, dst is the live copy and the prune would delete its files.
+			p.errorf(node.Line, "retention is only supported by the %s jobs with snapshot: true", JobCopyDIR)
+			delete(item.kv, "retention_days")
+		}
+		if jobType == JobCopyDIR && item.kv["modified_days"] != "" {
+			p.errorf(node.Line, "modified_days is only supported by the %s jobs", JobCopyMD)
+			delete(item.kv, "modified_days")
+		}
+		if jobType == JobCopyMD && item.compress {
+			p.errorf(node.Line, "compress is only supported by the %s jobs", JobCopyDIR)
+		}
+		if jobType == JobCopyMD && item.snapshot && mappingValue(node, "snapshot") != nil {
+			p.errorf(node.Line, "snapshot is only supported by the %s jobs", JobCopyDIR)
+		}
+
+		if schedule == nil || schedule.Kind != yaml.MappingNode {
+			p.errorf(node.Line, "missing required schedule mapping of %s", strings.Join(jobScheduleKeys, ", "))
+			continue
+		}
+		for i := 0; i+1 < len(schedule.Content); i += 2 {
+			key, value := schedule.Content[i].Value, schedule.Content[i+1]
+			if !inList(jobScheduleKeys, key) {
+				p.errorf(schedule.Content[i].Line, "unknown schedule key %q, allowed keys are: %s", key, strings.Join(jobScheduleKeys, ", "))
+				continue
+			}
+			item.kv[key] = strings.TrimSpace(value.Value)
+		}
+
+		// Validate the job the same way as the backup_items of its backup section.
+		item.section = jobSection(jobType, item.kv["interval"])
+		p.section = item.section
+		p.options = intervalOptions(mappingValue(backups, item.section), item.section)
+		kv := make(map[string]string)
+		for key, value := range item.kv {
+			if key != "name" && key != "type" && value != "" {
+				kv[key] = value
+			}
+		}
+		p.validate(node.Line, kv)
+		if len(p.errs) > nErrs {
+			continue
+		}
+		item.kv = kv
+		items = append(items, item)
+	}
+	return items, p.errs
+}
+
+// scalarList returns the values of a YAML sequence node, or a comma separated scalar node.
+func scalarList(node *yaml.Node) []string {
+	var values []string
+	if node.Kind == yaml.SequenceNode {
+		for _, v := range node.Content {
+			if s := strings.TrimSpace(v.Value); s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	for _, v := range strings.Split(node.Value, ",") {
+		if s := strings.TrimSpace(v); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// inList checks if the value is one of the list items.
+func inList(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateBackupItems moves every backup_items string of the backup sections into the structured jobs list
+// of the YAML document, the comments of each backup item are carried over to its new job mapping.
+func migrateBackupItems(doc *yaml.Node) (int, error) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return 0, fmt.Errorf("the config file must be a YAML mapping")
+	}
+
+	jobs := mappingValue(root, "jobs")
+	if jobs == nil {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "jobs",
+			HeadComment: "# Structured backup jobs, each one runs a copydir or copymd operation on its own schedule."}
+		jobs = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, key, jobs)
+	} else if isEmptyNode(jobs) {
+		*jobs = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", HeadComment: jobs.HeadComment, LineComment: jobs.LineComment}
+	}
+
+	names := make(map[string]bool)
+	for _, job := range jobs.Content {
+		if name := mappingValue(job, "name"); name != nil {
+			names[name.Value] = true
+		}
+	}
+
+	nMigrated := 0
+	backups := mappingValue(root, "backups")
+	for _, section := range BackupSections {
+		items := mappingValue(mappingValue(backups, section), "backup_items")
+		if items == nil || items.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		var kept []*yaml.Node
+		p := &backupItemParser{section: section}
+		for n, node := range items.Content {
+			if node.Kind != yaml.ScalarNode || isEmptyNode(node) {
+				kept = append(kept, node)
+				continue
+			}
+
+			kv, _ := p.parse(node.Line, node.Value)
+			name := fmt.Sprintf("%s_%d", section, n+1)
+			for i := 2; names[name]; i++ {
+				name = fmt.Sprintf("%s_%d_%d", section, n+1, i)
+			}
+			names[name] = true
+
+			// The line comment goes next to the job name, so it stays on the same job once it's encoded.
+			job := newJobNode(name, section, kv)
+			job.HeadComment, job.FootComment = node.HeadComment, node.FootComment
+			job.Content[1].LineComment = node.LineComment
+			jobs.Content = append(jobs.Content, job)
+			nMigrated++
+		}
+
+		// Keep an empty backup item, so the section still shows where the backup items used to be.
+		if len(kept) == 0 {
+			kept = append(kept, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+		}
+		items.Content = kept
+	}
+	return nMigrated, nil
+}
+
+// newJobNode converts the key and value pairs of a backup_items string into a structured job mapping.
+func newJobNode(name, section string, kv map[string]string) *yaml.Node {
+	jobType := JobCopyDIR
+	if section == SecCopyMD || section == SecCopyMDF {
+		jobType = JobCopyMD
+	}
+
+	job := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	addPair(job, "name", name, "!!str")
+	addPair(job, "type", jobType, "!!str")
+	addPair(job, "src", kv["src"], "!!str")
+	addPair(job, "dst", kv["dst"], "!!str")
+
+	schedule := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	addPair(schedule, "run_every", kv["run_every"], "!!int")
+	addPair(schedule, "interval", kv["interval"], "!!str")
+	if kv["run_at"] != "" {
+		// Quote it, otherwise the YAML 1.1 parsers read 11:45 as a sexagesimal number.
+		addPair(schedule, "run_at", kv["run_at"], "!!str")
+		schedule.Content[len(schedule.Content)-1].Style = yaml.DoubleQuotedStyle
+	}
+	job.Content = append(job.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "schedule"}, schedule)
+
+	if kv["retention_days"] != "" {
+		addPair(job, "retention", kv["retention_days"], "!!int")
+	}
+	if kv["modified_days"] != "" {
+		addPair(job, "modified_days", kv["modified_days"], "!!int")
+	}
+	return job
+}
+
+// addPair appends a key and its scalar value into the YAML mapping node.
+func addPair(mapping *yaml.Node, key, value, tag string) {
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value})
+}
@@ -0,0 +1,249 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrateBackupItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		nMigrated int
+		want      string
+	}{
+		{
+			name: "comments are kept",
+			config: `app_name: gokopy # the app
+backups:
+  copydir_daily:
+    interval_options: [days, monday]
+    backup_items:
+      # Documents every night
+      - src=/docs, dst=/backup/docs, run_every=1, interval=days, run_at=23:30, retention_days=-30; # keep a month
+  copymd_frequently:
+    # Sample items
+    backup_items:
+      - src=/a, dst=/b, run_every=30, interval=minutes, modified_days=-1;
+`,
+			nMigrated: 2,
+			want: `app_name: gokopy # the app
+backups:
+  copydir_daily:
+    interval_options: [days, monday]
+    backup_items:
+      -
+  copymd_frequently:
+    # Sample items
+    backup_items:
+      -
+# Structured backup jobs, each one runs a copydir or copymd operation on its own schedule.
+jobs:
+  # Documents every night
+  - name: copydir_daily_1 # keep a month
+    type: copydir
+    src: /docs
+    dst: /backup/docs
+    schedule:
+      run_every: 1
+      interval: days
+      run_at: "23:30"
+    retention: -30
+  - name: copymd_frequently_1
+    type: copymd
+    src: /a
+    dst: /b
+    schedule:
+      run_every: 30
+      interval: minutes
+    modified_days: -1
+`,
+		},
+		{
+			name: "existing jobs are kept",
+			config: `backups:
+  copymd_daily:
+    backup_items:
+      - 
+      - src=/a, dst=/b, run_every=1, interval=monday, run_at=07:00;
+# Structured jobs
+jobs:
+  - name: copymd_daily_2
+    type: copymd
+    src: /x
+    dst: /y
+    schedule: {run_every: 1, interval: hours}
+`,
+			nMigrated: 1,
+			want: `backups:
+  copymd_daily:
+    backup_items:
+      -
+# Structured jobs
+jobs:
+  - name: copymd_daily_2
+    type: copymd
+    src: /x
+    dst: /y
+    schedule: {run_every: 1, interval: hours}
+  - name: copymd_daily_2_2
+    type: copymd
+    src: /a
+    dst: /b
+    schedule:
+      run_every: 1
+      interval: monday
+      run_at: "07:00"
+`,
+		},
+		{
+			name: "empty jobs list",
+			config: `backups:
+  copydir_frequently:
+    backup_items:
+      - src=C:\a, dst=C:\b, run_every=15, interval=seconds;
+jobs: # migrated below
+`,
+			nMigrated: 1,
+			want: `backups:
+  copydir_frequently:
+    backup_items:
+      -
+jobs: # migrated below
+  - name: copydir_frequently_1
+    type: copydir
+    src: C:\a
+    dst: C:\b
+    schedule:
+      run_every: 15
+      interval: seconds
+`,
+		},
+		{
+			name: "nothing to migrate",
+			config: `backups:
+  copydir_daily:
+    backup_items:
+      - 
+jobs:
+  - name: docs
+`,
+			want: `backups:
+  copydir_daily:
+    backup_items:
+      -
+jobs:
+  - name: docs
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.config), &doc); err != nil {
+				t.Fatal(err)
+			}
+			nMigrated, err := migrateBackupItems(&doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nMigrated != tt.nMigrated {
+				t.Errorf("migrated = %d, want %d", nMigrated, tt.nMigrated)
+			}
+
+			var buf bytes.Buffer
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			enc.Close()
+			if got := buf.String(); got != tt.want {
+				t.Errorf("migrated config =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateBackupItemsRoundTrip(t *testing.T) {
+	config := `backups:
+  copydir_daily:
+    backup_items:
+      - src=/a, dst=/b, run_every=2, interval=Friday, run_at=11:45, retention_days=-90;
+  copydir_frequently:
+    backup_items:
+      - src=/a, dst=/c, run_every=5, interval=minutes;
+  copymd_daily:
+    backup_items:
+      - src=/a, dst=/d, run_every=1, interval=days, run_at=00:15, modified_days=-7;
+  copymd_frequently:
+    backup_items:
+      - src=/a, dst=/e, run_every=1, interval=hours;
+`
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup items as they're parsed before the migration.
+	want := make(map[string]map[string]string)
+	backups := mappingValue(&doc, "backups")
+	for _, section := range BackupSections {
+		p := &backupItemParser{section: section, options: defaultIntervalOptions[section]}
+		item := mappingValue(mappingValue(backups, section), "backup_items").Content[0]
+		kv, ok := p.parse(item.Line, item.Value)
+		if !ok {
+			t.Fatalf("%s: %v", section, p.errs)
+		}
+		want[section+"_1"] = kv
+	}
+
+	if _, err := migrateBackupItems(&doc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var migrated yaml.Node
+	if err := yaml.Unmarshal(data, &migrated); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retention of the copydir jobs needs the snapshots.
+	viper.Set("default.snapshot", true)
+	defer viper.Set("default.snapshot", false)
+	items, errs := parseJobs("config.yaml", &migrated)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := make(map[string]map[string]string)
+	for _, item := range items {
+		if item.section+"_1" != item.name {
+			t.Errorf("job %s is of the %s section", item.name, item.section)
+		}
+		got[item.name] = item.kv
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated jobs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// lsTimeFormat is the modification time format of the ls command.
+const lsTimeFormat = "2006-01-02 15:04:05"
+
+// The flags of the ls command.
+var (
+	lsJSON bool
+	lsTree bool
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the contents of an archive",
+	Long: `ls command lists the folders, files and symlinks of an archive with their mode, size and modification
+time, without extracting anything. The archive format is detected from its content like the dcdir command:
+tar.gz, tar.zst, tar.xz, tar.lz4, tar.bz2, tar or zip.
+
+The --json flag prints the entries as a JSON array instead, and the --tree flag prints them as a tree of
+folders, example:
+"D:\backup\documents.tar.gz" --tree`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := filepath.FromSlash(args[0])
+		if lsJSON && lsTree {
+			fmt.Println("Only one of the --json or --tree flags can be set.")
+			os.Exit(1)
+		}
+
+		entries, format, err := listArchive(src)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		switch {
+		case lsJSON:
+			err = printEntriesJSON(os.Stdout, entries)
+		case lsTree:
+			printEntriesTree(os.Stdout, src, entries)
+		default:
+			err = printEntries(os.Stdout, entries)
+			var total int64
+			for _, e := range entries {
+				total += e.size
+			}
+			fmt.Println("Format: ", format.name, " Number of Entries: ", len(entries), " Total Bytes: ", total)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// listArchive reads the entries of an archive without extracting their content.
+func listArchive(src string) ([]*archiveEntry, *archiveFormat, error) {
+	ar, format, err := openArchive(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ar.Close()
+
+	var entries []*archiveEntry
+	for {
+		e, _, err := ar.next()
+		if err == io.EOF {
+			return entries, format, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", src, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// entryType returns the type of an archive entry: dir, file, symlink or other.
+func entryType(e *archiveEntry) string {
+	switch {
+	case e.mode.IsDir():
+		return "dir"
+	case e.mode&os.ModeSymlink != 0:
+		return "symlink"
+	case e.mode.IsRegular():
+		return "file"
+	}
+	return "other"
+}
+
+// printEntries prints one line per entry: its mode, size, modification time and name.
+func printEntries(w io.Writer, entries []*archiveEntry) error {
+	for _, e := range entries {
+		name := e.name
+		if e.link != "" {
+			name += " -> " + e.link
+		}
+		if _, err := fmt.Fprintf(w, "%-11s %12d  %s  %s\n", e.mode, e.size, e.modTime.Local().Format(lsTimeFormat), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lsEntry is an archive entry of the ls --json output.
+type lsEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Link    string    `json:"link,omitempty"`
+}
+
+// printEntriesJSON prints the entries as an indented JSON array.
+func printEntriesJSON(w io.Writer, entries []*archiveEntry) error {
+	out := make([]lsEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, lsEntry{Name: e.name, Type: entryType(e), Mode: e.mode.String(), Size: e.size, ModTime: e.modTime, Link: e.link})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// lsNode is a folder or a file of the ls --tree output.
+type lsNode struct {
+	name     string
+	entry    *archiveEntry // It's nil for the folders that don't have their own entry
+	children []*lsNode
+	index    map[string]*lsNode
+}
+
+// child returns the named child node, it's added when it doesn't exist yet.
+func (n *lsNode) child(name string) *lsNode {
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &lsNode{name: name, index: map[string]*lsNode{}}
+	n.children = append(n.children, c)
+	n.index[name] = c
+	return c
+}
+
+// printEntriesTree prints the entries as a tree of folders in the order of the archive.
+func printEntriesTree(w io.Writer, src string, entries []*archiveEntry) {
+	root := &lsNode{index: map[string]*lsNode{}}
+	for _, e := range entries {
+		n := root
+		for _, part := range strings.Split(strings.Trim(strings.ReplaceAll(e.name, `\`, "/"), "/"), "/") {
+			if part != "" && part != "." {
+				n = n.child(part)
+			}
+		}
+		if n != root {
+			n.entry = e
+		}
+	}
+
+	fmt.Fprintln(w, filepath.Base(src))
+	printTreeNodes(w, root.children, "")
+}
+
+// printTreeNodes prints the nodes of a folder, the prefix draws the lines of their parent folders.
+func printTreeNodes(w io.Writer, nodes []*lsNode, prefix string) {
+	for i, n := range nodes {
+		branch, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		line := n.name
+		switch {
+		case n.entry == nil || n.entry.mode.IsDir():
+			line += "/"
+		case n.entry.link != "":
+			line += " -> " + n.entry.link
+		default:
+			line += fmt.Sprintf(" (%d bytes)", n.entry.size)
+		}
+		fmt.Fprintln(w, prefix+branch+line)
+		printTreeNodes(w, n.children, prefix+indent)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.Flags().BoolVar(&lsJSON, "json", false, "print the entries as a JSON array")
+	lsCmd.Flags().BoolVar(&lsTree, "tree", false, "print the entries as a tree of folders")
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest entry in the archives.
+const ManifestName = ".gokopy-manifest.json"
+
+// manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+// manifest records the files of an archive or a copy with their SHA-256 hash, to check them against later.
+type manifest struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Files   []manifestEntry `json:"files"`
+}
+
+// manifestEntry is a file of a manifest, its path is slash separated as it's stored in the archive.
+type manifestEntry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    os.FileMode `json:"mode"`
+	SHA256  string      `json:"sha256"`
+}
+
+// readManifest decodes a manifest, its version must be supported.
+func readManifest(r io.Reader) (*manifest, error) {
+	var m manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// newManifest returns an empty manifest of the current version.
+func newManifest() *manifest {
+	return &manifest{Version: manifestVersion, Created: time.Now().UTC(), Files: []manifestEntry{}}
+}
+
+// add records a file with its SHA-256 hash.
+func (m *manifest) add(name string, fi os.FileInfo, sum string) {
+	m.Files = append(m.Files, manifestEntry{Path: name, Size: fi.Size(), ModTime: fi.ModTime().UTC(), Mode: fi.Mode(), SHA256: sum})
+}
+
+// lookup returns the files of the manifest by their path.
+func (m *manifest) lookup() map[string]manifestEntry {
+	files := make(map[string]manifestEntry, len(m.Files))
+	for _, me := range m.Files {
+		files[me.Path] = me
+	}
+	return files
+}
+
+// addToArchive writes the manifest as the "name" entry of the archive.
+func (m *manifest) addToArchive(aw archiveWriter, name string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fi := manifestFileInfo{size: int64(len(data)), modTime: m.Created}
+	return aw.add(name, fi, "", bytes.NewReader(data))
+}
+
+// isManifestEntry checks if the slash separated archive entry name is the manifest, either in the archived
+// folder of the comdir archives, or at the top of the comfile archives and the earlier comdir archives.
+func isManifestEntry(name string) bool {
+	name = strings.TrimPrefix(name, "./")
+	dir, base := path.Split(name)
+	return base == ManifestName && !strings.Contains(strings.TrimSuffix(dir, "/"), "/")
+}
+
+// manifestFileInfo is the os.FileInfo of the manifest entry of an archive, it only exists in memory.
+type manifestFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fi manifestFileInfo) Name() string       { return ManifestName }
+func (fi manifestFileInfo) Size() int64        { return fi.size }
+func (fi manifestFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi manifestFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi manifestFileInfo) IsDir() bool        { return false }
+func (fi manifestFileInfo) Sys() interface{}   { return nil }
+
+// readManifestFile reads the manifest file of a folder, e.g. the sidecar manifest of a copy.
+func readManifestFile(file string) (*manifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readManifest(f)
+}
+
+// writeDirManifest writes the sidecar manifest of every file in the dir folder, the hash of a file is
+// taken from the manifest of the prevDir folder when its size and modification time haven't changed,
+// e.g. the files that are hard-linked from the previous snapshot or weren't copied again.
+func writeDirManifest(dir, prevDir string) (*manifest, error) {
+	prev := map[string]manifestEntry{}
+	if pm, err := readManifestFile(filepath.Join(prevDir, ManifestName)); err == nil {
+		prev = pm.lookup()
+	}
+
+	m := newManifest()
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == ManifestName {
+			return nil
+		}
+
+		if pe, ok := prev[name]; ok && pe.Size == fi.Size() && pe.ModTime.Unix() == fi.ModTime().Unix() && pe.SHA256 != "" {
+			m.add(name, fi, pe.SHA256)
+			return nil
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		m.add(name, fi, sum)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return m, writeFileAtomic(filepath.Join(dir, ManifestName), data)
+}
+
+// dirManifest returns the manifest of the files that compressDIR archives from the src folder, the sidecar
+// manifest of a copy is left out, it's replaced by the manifest of the archive.
+func dirManifest(src string, ignore []string, filter *fileFilter) (*manifest, error) {
+	m := newManifest()
+	sidecar := path.Join(filepath.Base(src), ManifestName)
+	err := walkArchiveTree(src, ignore, filter, func(file, name string, fi os.FileInfo) error {
+		if !fi.Mode().IsRegular() || name == sidecar {
+			return nil
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		m.add(name, fi, sum)
+		return nil
+	})
+	return m, err
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+)
+
+// deleteLimit is the --delete-limit safety threshold of the copydir --mirror mode, either a number of
+// files or a percentage of the files in the destination folder.
+type deleteLimit struct {
+	n       int
+	percent bool
+}
+
+// parseDeleteLimit parses "N" or "N%", an empty value has no limit.
+func parseDeleteLimit(s string) (*deleteLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	l := &deleteLimit{percent: strings.HasSuffix(s, "%")}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n < 0 || (l.percent && n > 100) {
+		return nil, fmt.Errorf("invalid --delete-limit %q, it must be a number of files or a percentage, e.g. 100 or 10%%", s)
+	}
+	l.n = n
+	return l, nil
+}
+
+// exceeded checks if deleting n of the total files is over the limit.
+func (l *deleteLimit) exceeded(n, total int) bool {
+	if l == nil {
+		return false
+	}
+	if l.percent {
+		return n*100 > l.n*total
+	}
+	return n > l.n
+}
+
+func (l *deleteLimit) String() string {
+	if l.percent {
+		return strconv.Itoa(l.n) + "%"
+	}
+	return strconv.Itoa(l.n)
+}
+
+// mirrorPlan lists the files and folders of the destination folder that aren't in the source folder.
+type mirrorPlan struct {
+	files []string // Extra files and symlinks, relative to dst
+	dirs  []string // Extra folders, relative to dst, the parent folders first
+	total int      // Files and symlinks of dst
+}
+
+// planMirror walks the dst folder for the files and folders that aren't in the src folder, or aren't the
+// same type of entry. The ignored files, the sidecar manifest and the quarantine folder are kept.
+func planMirror(src, dst, quarantine string, ignore []string) (*mirrorPlan, error) {
+	plan := &mirrorPlan{}
+	if quarantine != "" {
+		abs, err := filepath.Abs(quarantine)
+		if err != nil {
+			return nil, err
+		}
+		quarantine = abs
+	}
+
+	// extra is set while walking an extra folder, everything inside of it is extra too.
+	extra := ""
+	ig := newIgnoreMatcher(dst, ignore)
+	err := filepath.Walk(dst, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == dst {
+				return filepath.SkipDir // Nothing to delete from a new destination folder.
+			}
+			return err
+		}
+		if file == dst {
+			return nil
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		if ig.match(file, fi.IsDir()) || abs == quarantine {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dst, file)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestName {
+			return nil
+		}
+		if !fi.IsDir() {
+			plan.total++
+		}
+
+		if extra == "" || !strings.HasPrefix(rel, extra+string(filepath.Separator)) {
+			extra = ""
+			// The symlinks of the source are copied as the files they point at.
+			srcInfo, err := os.Stat(filepath.Join(src, rel))
+			if err == nil && srcInfo.IsDir() == fi.IsDir() {
+				return nil
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if fi.IsDir() {
+			plan.dirs = append(plan.dirs, rel)
+			if extra == "" {
+				extra = rel
+			}
+		} else {
+			plan.files = append(plan.files, rel)
+		}
+		return nil
+	})
+	return plan, err
+}
+
+// applyMirror deletes the extra files and folders of the plan from the dst folder, or moves them into
+// a timestamped folder of the quarantine folder when it's set. It returns the number of files removed.
+func applyMirror(plan *mirrorPlan, dst, quarantine string) (int, error) {
+	qdir := ""
+	if quarantine != "" {
+		qdir = filepath.Join(quarantine, time.Now().Format(BackupTimeFormat))
+	}
+
+	removed := 0
+	for _, rel := range plan.files {
+		file := filepath.Join(dst, rel)
+		if qdir != "" {
+			if err := moveFile(file, filepath.Join(qdir, rel)); err != nil {
+				return removed, err
+			}
+		} else if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed++
+		if IsLogCopiedFile {
+			if qdir != "" {
+				Sugar.Infow("quarantined_file", "file", file, "quarantine", qdir, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				fmt.Println("quarantined file: ", file)
+			} else {
+				Sugar.Infow("deleted_file", "file", file, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				fmt.Println("deleted file: ", file)
+			}
+		}
+	}
+
+	// The folders are removed the deepest first, those that still have ignored files are kept.
+	for i := len(plan.dirs) - 1; i >= 0; i-- {
+		os.Remove(filepath.Join(dst, plan.dirs[i]))
+	}
+	return removed, nil
+}
+
+// moveFile moves a file into the dst path, it's copied then removed when it can't be renamed, e.g. the
+// quarantine folder is on another drive.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	} else if err := copyFileTimes(src, dst, info); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// mirrorSource checks that the src folder of the mirror mode is a readable folder, it returns true
+// when it's empty.
+func mirrorSource(src string) (bool, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("aborted the mirror, the source folder can't be read: %v", err)
+	}
+	if !fi.IsDir() {
+		return false, fmt.Errorf("aborted the mirror, the source %s isn't a folder", src)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return false, fmt.Errorf("aborted the mirror, the source folder can't be read: %v", err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("aborted the mirror, the source folder can't be read: %v", err)
+	}
+	return len(names) == 0, nil
+}
+
+// runMirror deletes or quarantines the files of the dst folder that aren't in the src folder, the run
+// is aborted before anything is removed when the src folder can't be read, or it's empty while the dst
+// folder isn't and there's no explicit --delete-limit, or it would delete more files than the limit.
+func runMirror(src, dst string, opts backupOptions, ignore []string) (int, error) {
+	limit, err := parseDeleteLimit(opts.deleteLimit)
+	if err != nil {
+		return 0, err
+	}
+	empty, err := mirrorSource(src)
+	if err != nil {
+		return 0, err
+	}
+	plan, err := planMirror(src, dst, opts.quarantine, ignore)
+	if err != nil {
+		return 0, err
+	}
+	if empty && plan.total > 0 && limit == nil {
+		return 0, fmt.Errorf("aborted, the source folder %s is empty, the mirror would remove all the %d files of %s, "+
+			"set the --delete-limit flag to allow it", src, plan.total, dst)
+	}
+	if limit.exceeded(len(plan.files), plan.total) {
+		return 0, fmt.Errorf("aborted, the mirror would remove %d of the %d files of %s, more than the --delete-limit %s",
+			len(plan.files), plan.total, dst, limit)
+	}
+	if DryRun {
+		action := "delete"
+		if opts.quarantine != "" {
+			action = "quarantine"
+		}
+		for _, rel := range plan.files {
+			dryRunAction(action, filepath.Join(dst, rel), -1)
+		}
+		return len(plan.files), nil
+	}
+	return applyMirror(plan, dst, opts.quarantine)
+}
@@ -0,0 +1,260 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestTree creates the entries in the dir folder, the files with their body and modification time,
+// the folders when their name ends with "/" and the symlinks when their link is set.
+func writeTestTree(t *testing.T, dir string, entries []testEntry) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		file := filepath.Join(dir, filepath.FromSlash(e.name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case e.link != "":
+			if err := os.Symlink(e.link, file); err != nil {
+				t.Fatal(err)
+			}
+		case strings.HasSuffix(e.name, "/"):
+			if err := os.MkdirAll(file, 0755); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			if err := ioutil.WriteFile(file, []byte(e.body), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(file, entryTime(e), entryTime(e)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestParseDeleteLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *deleteLimit
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"100", &deleteLimit{n: 100}, false},
+		{" 10% ", &deleteLimit{n: 10, percent: true}, false},
+		{"0", &deleteLimit{}, false},
+		{"100%", &deleteLimit{n: 100, percent: true}, false},
+		{"101%", nil, true},
+		{"-1", nil, true},
+		{"10 files", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDeleteLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDeleteLimit(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDeleteLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteLimitExceeded(t *testing.T) {
+	tests := []struct {
+		limit    *deleteLimit
+		n, total int
+		want     bool
+	}{
+		{nil, 1000, 1000, false},
+		{&deleteLimit{n: 2}, 2, 10, false},
+		{&deleteLimit{n: 2}, 3, 10, true},
+		{&deleteLimit{}, 1, 10, true},
+		{&deleteLimit{n: 10, percent: true}, 1, 10, false},
+		{&deleteLimit{n: 10, percent: true}, 2, 10, true},
+		{&deleteLimit{n: 10, percent: true}, 1, 11, false},
+		{&deleteLimit{n: 100, percent: true}, 10, 10, false},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.exceeded(tt.n, tt.total); got != tt.want {
+			t.Errorf("%v.exceeded(%d, %d) = %v, want %v", tt.limit, tt.n, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestRunMirror(t *testing.T) {
+	dstEntries := []testEntry{{name: "a.txt", body: "a"}, {name: "b.txt", body: "b"}, {name: "old/"}, {name: "old/c.txt", body: "c"}}
+	tests := []struct {
+		name        string
+		src         []testEntry // The source folder is missing when it's nil
+		dst         []testEntry
+		limit       string
+		quarantine  bool
+		ignore      []string
+		want        []string // The dst folder after the mirror
+		quarantined []string // The quarantine folder of the run
+		removed     int
+		wantErr     string
+	}{
+		{
+			name:    "extra files and folders deleted",
+			src:     []testEntry{{name: "a.txt", body: "a"}},
+			dst:     dstEntries,
+			want:    []string{"a.txt"},
+			removed: 2,
+		},
+		{
+			name:    "missing source",
+			dst:     dstEntries,
+			want:    []string{"a.txt", "b.txt", "old/", "old/c.txt"},
+			wantErr: "the source folder can't be read",
+		},
+		{
+			name:    "empty source",
+			src:     []testEntry{},
+			dst:     dstEntries,
+			want:    []string{"a.txt", "b.txt", "old/", "old/c.txt"},
+			wantErr: "is empty",
+		},
+		{
+			name:    "empty source with a delete limit",
+			src:     []testEntry{},
+			dst:     dstEntries,
+			limit:   "100%",
+			want:    nil,
+			removed: 3,
+		},
+		{
+			name:    "empty source and destination",
+			src:     []testEntry{},
+			dst:     []testEntry{},
+			want:    nil,
+			removed: 0,
+		},
+		{
+			name:    "count limit exceeded",
+			src:     []testEntry{{name: "a.txt", body: "a"}},
+			dst:     dstEntries,
+			limit:   "1",
+			want:    []string{"a.txt", "b.txt", "old/", "old/c.txt"},
+			wantErr: "more than the --delete-limit 1",
+		},
+		{
+			name:    "count limit reached",
+			src:     []testEntry{{name: "a.txt", body: "a"}},
+			dst:     dstEntries,
+			limit:   "2",
+			want:    []string{"a.txt"},
+			removed: 2,
+		},
+		{
+			// 2 of the 3 files are 66%.
+			name:    "percent limit exceeded",
+			src:     []testEntry{{name: "a.txt", body: "a"}},
+			dst:     dstEntries,
+			limit:   "50%",
+			want:    []string{"a.txt", "b.txt", "old/", "old/c.txt"},
+			wantErr: "more than the --delete-limit 50%",
+		},
+		{
+			name:    "percent limit reached",
+			src:     []testEntry{{name: "a.txt", body: "a"}},
+			dst:     dstEntries,
+			limit:   "67%",
+			want:    []string{"a.txt"},
+			removed: 2,
+		},
+		{
+			name:        "quarantine instead of delete",
+			src:         []testEntry{{name: "a.txt", body: "a"}},
+			dst:         dstEntries,
+			quarantine:  true,
+			want:        []string{"a.txt"},
+			quarantined: []string{"b.txt", "old/", "old/c.txt"},
+			removed:     2,
+		},
+		{
+			// The ignored files aren't copied, so they're never in the source folder. The folder that still
+			// has one is kept.
+			name: "ignored files kept",
+			src:  []testEntry{{name: "a.txt", body: "a"}},
+			dst: []testEntry{{name: "a.txt", body: "a"}, {name: "b.log", body: "b"}, {name: "cache/"}, {name: "cache/c.txt", body: "c"},
+				{name: "old/"}, {name: "old/d.log", body: "d"}, {name: "old/e.txt", body: "e"}, {name: ManifestName, body: "{}"}},
+			ignore:  []string{"*.log", "cache/"},
+			want:    []string{ManifestName, "a.txt", "b.log", "cache/", "cache/c.txt", "old/", "old/d.log"},
+			removed: 1,
+		},
+		{
+			// The copy replaces them with the entries of the source.
+			name:    "file changed into a folder",
+			src:     []testEntry{{name: "p/"}, {name: "p/x.txt", body: "x"}, {name: "q", body: "q"}},
+			dst:     []testEntry{{name: "p", body: "p"}, {name: "q/"}, {name: "q/y.txt", body: "y"}},
+			want:    nil,
+			removed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := testTempDir(t)
+			defer cleanup()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if tt.src != nil {
+				writeTestTree(t, src, tt.src)
+			}
+			writeTestTree(t, dst, tt.dst)
+			opts := backupOptions{deleteLimit: tt.limit}
+			if tt.quarantine {
+				opts.quarantine = filepath.Join(dir, "quarantine")
+			}
+
+			removed, err := runMirror(src, dst, opts, tt.ignore)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed = %d, want %d", removed, tt.removed)
+			}
+			if got := listTree(t, dst, nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dst = %q, want %q", got, tt.want)
+			}
+
+			if !tt.quarantine {
+				return
+			}
+			runs, err := ioutil.ReadDir(opts.quarantine)
+			if err != nil || len(runs) != 1 {
+				t.Fatalf("quarantine runs = %v, %v", runs, err)
+			}
+			if got := listTree(t, filepath.Join(opts.quarantine, runs[0].Name()), nil); !reflect.DeepEqual(got, tt.quarantined) {
+				t.Errorf("quarantine = %q, want %q", got, tt.quarantined)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// BackupTimeFormat is the timestamp format of the dated backup outputs, e.g. 2020-03-01_234500.
+const BackupTimeFormat = "2006-01-02_150405"
+
+// snapshotArchiveName matches the archives of the comdir snapshots, e.g. folder_name_2020-03-01_234500.tar.gz,
+// the extension must also be one of the archive formats that comdir writes.
+var snapshotArchiveName = regexp.MustCompile(`^.+_(\d{4}-\d{2}-\d{2}_\d{6})\.([a-z0-9.]+)$`)
+
+// pruneRetentionDays is the --retention-days flag of the prune command.
+var pruneRetentionDays int
+
+// datedBackup is a backup output found in the destination folder with its timestamp.
+type datedBackup struct {
+	path string
+	time time.Time
+}
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete the dated backups older than the retention days",
+	Long: `prune command deletes the snapshots of a job folder, "dst/<job>", that are older than the retention days,
+either the copydir snapshot folders named exactly with their timestamp, e.g. "2020-03-01_234500", or the
+comdir snapshot archives, e.g. "folder_name_2020-03-01_234500.tar.gz". Any other file or folder is kept.
+
+The newest dated backup is always kept, even if it's older than the retention days.
+
+Without any arguments, it prunes the job folder of every copydir backup item or job of the 'config.yaml'
+file that has the retention_days setting in snapshot mode, otherwise it prunes the specified job folder:
+"D:\backup_destination\documents" --retention-days -30
+
+Use the --dry-run flag to list the backups that would be deleted without deleting them.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			if pruneRetentionDays >= 0 {
+				fmt.Println("--retention-days must be a negative number of days, e.g. -30")
+				return
+			}
+			// Errors are already reported to the user's console and the logs.
+			runPrune(filepath.FromSlash(args[0]), pruneRetentionDays, DryRun)
+			return
+		}
+
+		if err := LoadBackupItems(); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return
+		}
+		for n := 0; n < len(MapCopyDIRD); n++ {
+			if bk := MapCopyDIRD[n]; bk.retentionDays < 0 && bk.snapshot {
+				opts := backupOptions{job: bk.name, snapshot: bk.snapshot}
+				runPrune(backupDir(filepath.FromSlash(bk.dst), opts), bk.retentionDays, DryRun)
+			}
+		}
+		for n := 0; n < len(MapCopyDIRF); n++ {
+			if bk := MapCopyDIRF[n]; bk.retentionDays < 0 && bk.snapshot {
+				opts := backupOptions{job: bk.name, snapshot: bk.snapshot}
+				runPrune(backupDir(filepath.FromSlash(bk.dst), opts), bk.retentionDays, DryRun)
+			}
+		}
+	},
+}
+
+// runPrune deletes the dated backups in the dst folder that are older than the retention days,
+// it's shared by the prune command and the scheduled copydir backup items.
+func runPrune(dst string, retentionDays int, dryRun bool) (int, error) {
+	msg := `Start pruning the backups older than the retention days:`
+	fmt.Println(msg, dst, retentionDays)
+	Sugar.Infow(msg, "dst", dst, "retention_days", retentionDays, "dry_run", dryRun, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	backups, err := datedBackups(dst)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return 0, err
+	}
+
+	// Always keep the newest backup, even if it's older than the retention days.
+	cutOff := time.Now().AddDate(0, 0, retentionDays)
+	numPruned := 0
+	for i := 0; i < len(backups)-1; i++ {
+		bk := backups[i]
+		if !bk.time.Before(cutOff) {
+			continue
+		}
+
+		if dryRun {
+			dryRunAction("delete", bk.path, -1)
+			numPruned++
+			continue
+		}
+
+		if err := os.RemoveAll(bk.path); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			continue
+		}
+		numPruned++
+		fmt.Println("deleted: ", bk.path)
+		Sugar.Infow("deleted", "path", bk.path, "backup_time", bk.time.Format(itrlog.LogTimeFormat), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	}
+
+	msg = dryRunMsg(`Done pruning the backups:`)
+	fmt.Println(msg, dst, " Number of Backups Deleted: ", numPruned)
+	Sugar.Infow(msg, "dst", dst, "deleted", numPruned, "dry_run", dryRun, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	return numPruned, nil
+}
+
+// backupTime returns the timestamp of a backup that gokopy created, either a copydir snapshot folder named
+// exactly with its timestamp, e.g. 2020-03-01_234500, or a comdir snapshot archive, e.g.
+// folder_name_2020-03-01_234500.tar.gz. Any other file or folder isn't a backup, so it's never deleted.
+func backupTime(fd os.FileInfo) (time.Time, bool) {
+	stamp := ""
+	switch {
+	case fd.IsDir():
+		stamp = fd.Name()
+	case fd.Mode().IsRegular():
+		m := snapshotArchiveName.FindStringSubmatch(fd.Name())
+		if m == nil || !inList(writableFormats(), m[2]) {
+			return time.Time{}, false
+		}
+		stamp = m[1]
+	default:
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(BackupTimeFormat, stamp, time.Local)
+	if err != nil || t.Format(BackupTimeFormat) != stamp {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// datedBackups returns the dated backup outputs of the dst folder, from the oldest to the newest one.
+func datedBackups(dst string) ([]datedBackup, error) {
+	fds, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []datedBackup
+	for _, fd := range fds {
+		if fd.Mode()&os.ModeSymlink != 0 {
+			continue // e.g. the "latest" symlink
+		}
+		if t, ok := backupTime(fd); ok {
+			backups = append(backups, datedBackup{path: filepath.Join(dst, fd.Name()), time: t})
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.Before(backups[j].time) })
+	return backups, nil
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().IntVar(&pruneRetentionDays, "retention-days", 0, "negative number of days to keep the backups, e.g. -30")
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBackupTime(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	stamp := time.Date(2020, time.March, 1, 23, 45, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		isDir  bool
+		link   bool
+		wantOK bool
+	}{
+		{"2020-03-01_234500", true, false, true},
+		{"docs_2020-03-01_234500.tar.gz", false, false, true},
+		{"my_docs_2020-03-01_234500.zip", false, false, true},
+		{"docs_2020-03-01_234500.tar.zst", false, false, true},
+		// The timestamp must be the whole folder name, and a valid date.
+		{"latest", true, false, false},
+		{"docs", true, false, false},
+		{"2020-03-01_234500_old", true, false, false},
+		{"2020-13-01_234500", true, false, false},
+		{"2020-3-1_234500", true, false, false},
+		// The archives must have a writable archive extension.
+		{"docs_2020-03-01_234500.txt", false, false, false},
+		{"docs_2020-03-01_234500", false, false, false},
+		{"_2020-03-01_234500.tar.gz", false, false, false},
+		{"2020-03-01_234500", false, false, false},
+		{"docs_2020-02-30_234500.tar.gz", false, false, false},
+		// The symlinks, e.g. "latest", aren't backups.
+		{"2020-03-01_234500", false, true, false},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, tt.name)
+		os.RemoveAll(file)
+		var err error
+		switch {
+		case tt.link:
+			err = os.Symlink(".", file)
+		case tt.isDir:
+			err = os.Mkdir(file, 0755)
+		default:
+			err = ioutil.WriteFile(file, nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := backupTime(fi)
+		if ok != tt.wantOK {
+			t.Errorf("backupTime(%q) ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && !got.Equal(stamp) {
+			t.Errorf("backupTime(%q) = %v, want %v", tt.name, got, stamp)
+		}
+	}
+}
+
+func TestRunPrune(t *testing.T) {
+	now := time.Now()
+	stamp := func(days int) string { return now.AddDate(0, 0, days).Format(BackupTimeFormat) }
+	backups := []testEntry{
+		{name: stamp(-40) + "/"},
+		{name: stamp(-40) + "/a.txt", body: "a"},
+		{name: "docs_" + stamp(-20) + ".tar.gz", body: "archive"},
+		{name: stamp(-5) + "/"},
+		// Every other file or folder is kept, whatever its time.
+		{name: "latest", link: stamp(-5)},
+		{name: "notes.txt", body: "notes", modTime: now.AddDate(0, 0, -100)},
+		{name: "old/"},
+		{name: "docs_" + stamp(-50) + ".txt", body: "not an archive"},
+	}
+	others := []string{"docs_" + stamp(-50) + ".txt", "latest -> " + stamp(-5), "notes.txt", "old/"}
+	tests := []struct {
+		name          string
+		entries       []testEntry
+		retentionDays int
+		dryRun        bool
+		want          []string // The backups left, on top of the others
+		pruned        int
+	}{
+		{
+			name:          "older than the retention days",
+			entries:       backups,
+			retentionDays: -30,
+			want:          []string{"docs_" + stamp(-20) + ".tar.gz", stamp(-5) + "/"},
+			pruned:        1,
+		},
+		{
+			name:          "archives too",
+			entries:       backups,
+			retentionDays: -10,
+			want:          []string{stamp(-5) + "/"},
+			pruned:        2,
+		},
+		{
+			// Both are older than the retention days.
+			name:          "newest always kept",
+			entries:       []testEntry{{name: stamp(-60) + "/"}, {name: "docs_" + stamp(-45) + ".tar.gz"}},
+			retentionDays: -30,
+			want:          []string{"docs_" + stamp(-45) + ".tar.gz"},
+			pruned:        1,
+		},
+		{
+			name:          "retention of 0 days",
+			entries:       backups,
+			retentionDays: 0,
+			want:          []string{stamp(-5) + "/"},
+			pruned:        2,
+		},
+		{
+			name:          "dry run",
+			entries:       backups,
+			retentionDays: -10,
+			dryRun:        true,
+			want:          []string{stamp(-40) + "/", stamp(-40) + "/a.txt", "docs_" + stamp(-20) + ".tar.gz", stamp(-5) + "/"},
+			pruned:        2,
+		},
+		{
+			name:          "no backups",
+			entries:       []testEntry{{name: "latest", link: "nowhere"}, {name: "notes.txt", body: "notes"}},
+			retentionDays: 0,
+			want:          []string{"latest -> nowhere", "notes.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := testTempDir(t)
+			defer cleanup()
+			writeTestTree(t, dir, tt.entries)
+
+			pruned, err := runPrune(dir, tt.retentionDays, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pruned != tt.pruned {
+				t.Errorf("pruned = %d, want %d", pruned, tt.pruned)
+			}
+			// The others are only in the backups entries.
+			want := tt.want
+			if len(tt.entries) == len(backups) {
+				want = append(append([]string{}, tt.want...), others...)
+			}
+			got := listTree(t, dir, nil)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("left = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDatedBackups(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	writeTestTree(t, dir, []testEntry{{name: "2020-03-02_000000/"}, {name: "docs_2020-03-01_120000.tar.gz"}, {name: "2020-03-01_000000/"},
+		{name: "latest", link: "2020-03-02_000000"}, {name: "2020-03-03_000000.bak/"}})
+
+	backups, err := datedBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, bk := range backups {
+		got = append(got, filepath.Base(bk.path))
+	}
+	want := []string{"2020-03-01_000000", "docs_2020-03-01_120000.tar.gz", "2020-03-02_000000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("datedBackups = %q, want %q", got, want)
+	}
+
+	if _, err := datedBackups(filepath.Join(dir, "missing")); err == nil {
+		t.Error("datedBackups of a missing folder has no error")
+	}
+}
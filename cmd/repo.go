@@ -0,0 +1,181 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+	"github.com/itrepablik/kopy"
+
+	"github.com/spf13/cobra"
+)
+
+// repoBackupJob is the --job flag of the repo backup command.
+var repoBackupJob string
+
+// repoCmd represents the repo command
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Deduplicating backup repository",
+	Long: `repo command groups the sub-commands of a deduplicating backup repository, it's meant for the large and
+mostly unchanged directories where copydir would copy every file and comdir would compress everything again.
+
+The files are split into content-defined chunks, each chunk is stored only once in the repository by its
+SHA-256 hash, and every snapshot records its tree of folders and files as a manifest. The chunks shared by
+the snapshots or the files don't use any more disk space.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd)
+	},
+}
+
+// repoInitCmd represents the repo init command
+var repoInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a new empty backup repository",
+	Long: `repo init command creates a new empty deduplicating backup repository in the specified folder, example:
+"D:\backup_repository"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := filepath.FromSlash(args[0])
+		if _, err := initRepository(dir); err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg := `Successfully created the backup repository:`
+		fmt.Println(msg, dir)
+		Sugar.Infow(msg, "repo", dir, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// repoBackupCmd represents the repo backup command
+var repoBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Store a new snapshot of a folder in the backup repository",
+	Long: `repo backup command stores a new snapshot of the entire folder in the backup repository, only the chunks
+that the repository doesn't have yet are written. The snapshot is named after its job, which is the base name
+of the folder unless the --job flag is set, and its timestamp, e.g. "documents/2020-03-01_234500".
+
+The ignore list of the 'config.yaml' file applies the same as the copydir command, example:
+"D:\backup_repository" "C:\source_folder" --job documents`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, src := filepath.FromSlash(args[0]), filepath.FromSlash(args[1])
+		job := repoBackupJob
+		if job == "" {
+			job = kopy.FileNameWOExt(filepath.Base(src))
+		}
+		if !jobNameFormat.MatchString(job) {
+			fmt.Println("--job must only contain letters, digits, '.', '_' or '-':", job)
+			os.Exit(1)
+		}
+		if err := runRepoBackup(dir, src, job, nil); err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+// repoRestoreCmd represents the repo restore command
+var repoRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a snapshot of the backup repository",
+	Long: `repo restore command writes the folders and files of a snapshot into the destination folder, the snapshot
+is either "<job>/<YYYY-MM-DD_HHMMSS>" or only the job name for its newest snapshot. Every chunk is checked
+against its SHA-256 hash while it's restored, example:
+"D:\backup_repository" documents/2020-03-01_234500 "C:\restored_folder"`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, ref, dst := filepath.FromSlash(args[0]), args[1], filepath.FromSlash(args[2])
+		repo, err := openRepository(dir)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		m, ref, err := repo.snapshot(ref)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg := `Starts restoring the snapshot: `
+		fmt.Println(msg, ref)
+		Sugar.Infow(msg, "repo", dir, "snapshot", ref, "dst", dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+		st, err := repo.restore(m, dst, logRepoFile("restored_file", "restored file: "))
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg = `Successfully restored the snapshot: `
+		fmt.Println(msg, ref, " Number of Files Restored: ", st.files, " Total Bytes: ", st.totalBytes)
+		Sugar.Infow(msg, "snapshot", ref, "dst", dst, "files_restored", st.files, "total_bytes", st.totalBytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// runRepoBackup stores a new snapshot of the src folder in the repository.
+func runRepoBackup(dir, src, job string, ignore []string) error {
+	repo, err := openRepository(dir)
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	msg := `Starts backing up the folder into the repository: `
+	fmt.Println(msg, src)
+	Sugar.Infow(msg, "src", src, "repo", dir, "job", job, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+	st, err := repo.backup(src, job, IgnoreList(ignore), logRepoFile("backed_up_file", "backed up file: "))
+	if err != nil {
+		fmt.Println(err)
+		Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return err
+	}
+
+	msg = `Successfully backed up the folder into the repository: `
+	fmt.Println(msg, src, " Snapshot: ", st.snapshotRef, " Number of Files: ", st.files, " New Chunks: ", st.newChunks, "of", st.chunks, " New Bytes: ", st.newBytes, "of", st.totalBytes)
+	Sugar.Infow(msg, "src", src, "repo", dir, "snapshot", st.snapshotRef, "files", st.files, "chunks", st.chunks, "new_chunks", st.newChunks,
+		"total_bytes", st.totalBytes, "new_bytes", st.newBytes, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	return nil
+}
+
+// logRepoFile returns the callback that logs every backed up or restored file, only when it's enabled
+// by the logging.log_copied_file setting.
+func logRepoFile(logMsg, consoleMsg string) func(path string, st repoStats) {
+	return func(path string, st repoStats) {
+		if IsLogCopiedFile {
+			Sugar.Infow(logMsg, "file", path, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			fmt.Println(consoleMsg, path)
+		}
+	}
+}
+
+func init() {
+	repoCmd.AddCommand(repoInitCmd)
+	repoCmd.AddCommand(repoBackupCmd)
+	repoCmd.AddCommand(repoRestoreCmd)
+	rootCmd.AddCommand(repoCmd)
+	repoBackupCmd.Flags().StringVar(&repoBackupJob, "job", "", "job name of the snapshots (default is the src base name)")
+}
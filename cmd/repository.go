@@ -0,0 +1,524 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout of a deduplicating backup repository:
+//
+//	<repo>/gokopy-repo.json                  the repository settings
+//	<repo>/chunks/<ab>/<abcdef...>           each chunk stored once by its SHA-256 hash
+//	<repo>/snapshots/<job>/<stamp>.json      the manifest of each snapshot's tree
+const (
+	RepoConfigFile   = "gokopy-repo.json"
+	RepoChunksDir    = "chunks"
+	RepoSnapshotsDir = "snapshots"
+	RepoVersion      = 1
+)
+
+// Content-defined chunk sizes, a chunk ends where the rolling hash matches the mask, so the unchanged parts
+// of a file still produce the same chunks when some bytes are inserted or removed before them.
+const (
+	chunkMinSize = 256 << 10
+	chunkMaxSize = 4 << 20
+	chunkMask    = 1<<20 - 1 // ~1 MiB average after the minimum size
+)
+
+// Entry types of the snapshot manifests.
+const (
+	RepoEntryDir     = "dir"
+	RepoEntryFile    = "file"
+	RepoEntrySymlink = "symlink"
+)
+
+// gearTable is the random table of the rolling gear hash, it's generated from a fixed seed because
+// the chunk boundaries must never change between the versions of gokopy.
+var gearTable = func() (t [256]uint64) {
+	seed := uint64(0x676f6b6f7079) // "gokopy"
+	for i := range t {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// repoConfig is the gokopy-repo.json settings of a repository.
+type repoConfig struct {
+	Version      int       `json:"version"`
+	ChunkMinSize int       `json:"chunk_min_size"`
+	ChunkMaxSize int       `json:"chunk_max_size"`
+	ChunkMask    uint64    `json:"chunk_mask"`
+	Created      time.Time `json:"created"`
+}
+
+// repoManifest records the tree of a snapshot, every file refers to its chunks in order.
+type repoManifest struct {
+	Job   string      `json:"job"`
+	Src   string      `json:"src"`
+	Time  time.Time   `json:"time"`
+	Files []repoEntry `json:"files"`
+}
+
+// repoEntry is a folder, file or symlink of a snapshot, its path is slash separated and relative to the src.
+type repoEntry struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Target  string      `json:"target,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// repoStats counts the files and chunks of a repository backup or restore.
+type repoStats struct {
+	files       int
+	chunks      int
+	newChunks   int
+	newBytes    int64
+	totalBytes  int64
+	snapshotRef string
+}
+
+// repository is an opened deduplicating backup repository.
+type repository struct {
+	dir    string
+	config repoConfig
+}
+
+// initRepository creates a new empty repository in the dir folder.
+func initRepository(dir string) (*repository, error) {
+	if _, err := os.Stat(filepath.Join(dir, RepoConfigFile)); err == nil {
+		return nil, fmt.Errorf("%s is already a gokopy repository", dir)
+	}
+	for _, d := range []string{dir, filepath.Join(dir, RepoChunksDir), filepath.Join(dir, RepoSnapshotsDir)} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &repository{dir: dir, config: repoConfig{Version: RepoVersion, ChunkMinSize: chunkMinSize,
+		ChunkMaxSize: chunkMaxSize, ChunkMask: chunkMask, Created: time.Now()}}
+	data, err := json.MarshalIndent(r.config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, RepoConfigFile), data); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// openRepository opens an existing repository created by initRepository.
+func openRepository(dir string) (*repository, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, RepoConfigFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s is not a gokopy repository, use the 'gokopy repo init' command first", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &repository{dir: dir}
+	if err := json.Unmarshal(data, &r.config); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, RepoConfigFile), err)
+	}
+	if r.config.Version != RepoVersion {
+		return nil, fmt.Errorf("%s: unsupported repository version %d", dir, r.config.Version)
+	}
+	if r.config.ChunkMinSize != chunkMinSize || r.config.ChunkMaxSize != chunkMaxSize || r.config.ChunkMask != chunkMask {
+		return nil, fmt.Errorf("%s: unsupported chunk sizes of the repository", dir)
+	}
+	return r, nil
+}
+
+// chunkPath returns the path of a chunk by its hex encoded SHA-256 hash.
+func (r *repository) chunkPath(sum string) string {
+	return filepath.Join(r.dir, RepoChunksDir, sum[:2], sum)
+}
+
+// putChunk stores the chunk unless the repository already has it, it returns true when it's a new chunk.
+func (r *repository) putChunk(data []byte) (string, bool, error) {
+	h := sha256.Sum256(data)
+	sum := hex.EncodeToString(h[:])
+	path := r.chunkPath(sum)
+	if _, err := os.Stat(path); err == nil {
+		return sum, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", false, err
+	}
+	return sum, true, writeFileAtomic(path, data)
+}
+
+// getChunk reads a chunk and checks it still matches its SHA-256 hash.
+func (r *repository) getChunk(sum string) ([]byte, error) {
+	if len(sum) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk hash %q", sum)
+	}
+	data, err := ioutil.ReadFile(r.chunkPath(sum))
+	if err != nil {
+		return nil, err
+	}
+	if h := sha256.Sum256(data); hex.EncodeToString(h[:]) != sum {
+		return nil, fmt.Errorf("chunk %s is corrupted", sum)
+	}
+	return data, nil
+}
+
+// backup stores the src folder as a new snapshot of the job, the files and folders matching the ignore
+// patterns are skipped.
+func (r *repository) backup(src, job string, ignore []string, onFile func(path string, st repoStats)) (repoStats, error) {
+	var st repoStats
+	m := repoManifest{Job: job, Src: src, Time: time.Now()}
+	ig := newIgnoreMatcher(src, ignore)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ig.match(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		e := repoEntry{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm(), ModTime: info.ModTime()}
+		switch {
+		case info.IsDir():
+			e.Type = RepoEntryDir
+		case info.Mode()&os.ModeSymlink != 0:
+			e.Type = RepoEntrySymlink
+			if e.Target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			e.Type, e.Size = RepoEntryFile, info.Size()
+			if e.Chunks, err = r.storeFile(path, &st); err != nil {
+				return err
+			}
+			st.files++
+			st.totalBytes += e.Size
+			if onFile != nil {
+				onFile(path, st)
+			}
+		default:
+			return nil // Devices, sockets and named pipes aren't backed up.
+		}
+		m.Files = append(m.Files, e)
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+
+	// The manifest is only written when all its chunks are stored, so a failed backup leaves no snapshot behind.
+	jobDir := filepath.Join(r.dir, RepoSnapshotsDir, job)
+	if err := os.MkdirAll(jobDir, os.ModePerm); err != nil {
+		return st, err
+	}
+	stamp := snapshotTime(jobDir, func(stamp string) string { return stamp + ".json" })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return st, err
+	}
+	st.snapshotRef = job + "/" + stamp
+	return st, writeFileAtomic(filepath.Join(jobDir, stamp+".json"), data)
+}
+
+// storeFile splits the file into content-defined chunks and stores the new ones.
+func (r *repository) storeFile(path string, st *repoStats) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sums []string
+	c := newChunker(f)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			return sums, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum, isNew, err := r.putChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		st.chunks++
+		if isNew {
+			st.newChunks++
+			st.newBytes += int64(len(data))
+		}
+		sums = append(sums, sum)
+	}
+}
+
+// snapshot reads the manifest of a snapshot, the ref is "<job>/<YYYY-MM-DD_HHMMSS>" or only the job name
+// for its newest snapshot.
+func (r *repository) snapshot(ref string) (repoManifest, string, error) {
+	var m repoManifest
+	ref = filepath.ToSlash(ref)
+	job, stamp := ref, ""
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		job, stamp = ref[:i], ref[i+1:]
+	}
+	if job == "" || strings.Contains(job, "..") || strings.Contains(job, "/") {
+		return m, "", fmt.Errorf("invalid snapshot %q, it must be <job> or <job>/<YYYY-MM-DD_HHMMSS>", ref)
+	}
+
+	jobDir := filepath.Join(r.dir, RepoSnapshotsDir, job)
+	if stamp == "" || stamp == LatestSnapshot {
+		stamps, err := r.snapshots(job)
+		if err != nil {
+			return m, "", err
+		}
+		if len(stamps) == 0 {
+			return m, "", fmt.Errorf("the job %q has no snapshots in %s", job, r.dir)
+		}
+		stamp = stamps[len(stamps)-1]
+	}
+	if _, err := time.Parse(BackupTimeFormat, stamp); err != nil {
+		return m, "", fmt.Errorf("invalid snapshot %q, it must be <job> or <job>/<YYYY-MM-DD_HHMMSS>", ref)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(jobDir, stamp+".json"))
+	if err != nil {
+		return m, "", err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, "", fmt.Errorf("snapshot %s/%s: %v", job, stamp, err)
+	}
+	return m, job + "/" + stamp, nil
+}
+
+// snapshots returns the snapshot timestamps of the job, from the oldest to the newest one.
+func (r *repository) snapshots(job string) ([]string, error) {
+	fds, err := ioutil.ReadDir(filepath.Join(r.dir, RepoSnapshotsDir, job))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stamps []string
+	for _, fd := range fds {
+		stamp := strings.TrimSuffix(fd.Name(), ".json")
+		if _, err := time.Parse(BackupTimeFormat, stamp); err == nil && stamp != fd.Name() {
+			stamps = append(stamps, stamp)
+		}
+	}
+	sort.Strings(stamps)
+	return stamps, nil
+}
+
+// restore writes the tree of the snapshot manifest into the dst folder, every chunk is checked against its hash.
+// The symlinks are restored last and nothing is written through a symlink, so the snapshot can't write
+// outside of the dst folder.
+func (r *repository) restore(m repoManifest, dst string, onFile func(path string, st repoStats)) (repoStats, error) {
+	var st repoStats
+	var dirs, links []repoEntry
+	for _, e := range m.Files {
+		rel := filepath.FromSlash(e.Path)
+		if e.Path == "" || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return st, fmt.Errorf("invalid path %q in the snapshot manifest", e.Path)
+		}
+		if e.Type == RepoEntrySymlink {
+			links = append(links, e)
+			continue
+		}
+		if link := symlinkInPath(dst, filepath.ToSlash(filepath.Clean(rel)), e.Type == RepoEntryDir); link != "" {
+			return st, fmt.Errorf("%s goes through the symlink %s of the destination folder", e.Path, link)
+		}
+		path := filepath.Join(dst, rel)
+
+		switch e.Type {
+		case RepoEntryDir:
+			if err := os.MkdirAll(path, e.Mode|0700); err != nil {
+				return st, err
+			}
+			dirs = append(dirs, e)
+		case RepoEntryFile:
+			if err := r.restoreFile(e, path); err != nil {
+				return st, err
+			}
+			st.files++
+			st.chunks += len(e.Chunks)
+			st.totalBytes += e.Size
+			if onFile != nil {
+				onFile(path, st)
+			}
+		default:
+			return st, fmt.Errorf("unknown entry type %q of %s in the snapshot manifest", e.Type, e.Path)
+		}
+	}
+
+	for _, e := range links {
+		rel := filepath.FromSlash(e.Path)
+		if link := symlinkInPath(dst, filepath.ToSlash(filepath.Clean(rel)), false); link != "" {
+			return st, fmt.Errorf("%s goes through the symlink %s of the destination folder", e.Path, link)
+		}
+		path := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return st, err
+		}
+		os.Remove(path)
+		if err := os.Symlink(e.Target, path); err != nil {
+			return st, err
+		}
+	}
+
+	// The folders get their permissions and modification time once their files are written, the deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(dst, filepath.FromSlash(dirs[i].Path))
+		os.Chmod(path, dirs[i].Mode)
+		os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime)
+	}
+	return st, nil
+}
+
+// restoreFile writes a file of the snapshot from its chunks.
+func (r *repository) restoreFile(e repoEntry, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, e.Mode|0200)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, sum := range e.Chunks {
+		data, err := r.getChunk(sum)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %v", e.Path, err)
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		size += int64(len(data))
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if size != e.Size {
+		return fmt.Errorf("%s: restored %d bytes, expected %d bytes", e.Path, size, e.Size)
+	}
+	if err := os.Chmod(path, e.Mode); err != nil {
+		return err
+	}
+	return os.Chtimes(path, e.ModTime, e.ModTime)
+}
+
+// chunker splits a stream into content-defined chunks with a gear rolling hash.
+type chunker struct {
+	r   io.Reader
+	buf []byte
+	n   int // Number of buffered bytes
+	eof bool
+}
+
+// newChunker returns a chunker of the r stream.
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, chunkMaxSize)}
+}
+
+// next returns the next chunk of the stream, or io.EOF when there are no more chunks.
+func (c *chunker) next() ([]byte, error) {
+	if !c.eof && c.n < len(c.buf) {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			c.eof = true
+		default:
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := chunkBoundary(c.buf[:c.n])
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// chunkBoundary returns the size of the first chunk of the data, the data is at most chunkMaxSize bytes.
+func chunkBoundary(data []byte) int {
+	if len(data) <= chunkMinSize {
+		return len(data)
+	}
+	var h uint64
+	for i := chunkMinSize; i < len(data); i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// writeFileAtomic writes the data into a temporary file and renames it, so the file is either complete or missing.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRandomData returns n bytes of reproducible random data.
+func testRandomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// testChunks splits the data with the chunker of the repositories.
+func testChunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestChunker(t *testing.T) {
+	for _, size := range []int{0, 1, chunkMinSize, chunkMinSize + 1, chunkMaxSize, 3*chunkMaxSize + 12345} {
+		data := testRandomData(size, int64(size))
+		chunks := testChunks(t, data)
+		if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+			t.Errorf("size %d: the chunks don't make the data again", size)
+		}
+		for i, chunk := range chunks {
+			if len(chunk) > chunkMaxSize || (len(chunk) < chunkMinSize && i < len(chunks)-1) {
+				t.Errorf("size %d: chunk %d has %d bytes", size, i, len(chunk))
+			}
+		}
+	}
+
+	// The data without any boundary is cut at the maximum size.
+	if got := chunkBoundary(make([]byte, chunkMaxSize)); got > chunkMaxSize {
+		t.Errorf("chunkBoundary = %d, want at most %d", got, chunkMaxSize)
+	}
+	if got := chunkBoundary(make([]byte, chunkMinSize)); got != chunkMinSize {
+		t.Errorf("chunkBoundary = %d, want %d", got, chunkMinSize)
+	}
+}
+
+func TestChunkerDedup(t *testing.T) {
+	data := testRandomData(16<<20, 1)
+	edited := append(append(append([]byte{}, data[:8<<20]...), "a few inserted bytes"...), data[8<<20:]...)
+
+	sums := map[[sha256.Size]byte]bool{}
+	for _, chunk := range testChunks(t, data) {
+		sums[sha256.Sum256(chunk)] = true
+	}
+	chunks := testChunks(t, edited)
+	changed := 0
+	for _, chunk := range chunks {
+		if !sums[sha256.Sum256(chunk)] {
+			changed++
+		}
+	}
+	// Only the chunks around the edit change, the boundaries are found again after it.
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of the %d chunks changed, want 1 or 2", changed, len(chunks))
+	}
+}
+
+// testRepository returns a new repository and the src folder with the test files.
+func testRepository(t *testing.T, dir string) (*repository, string) {
+	t.Helper()
+	repo, err := initRepository(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "docs")
+	writeTestTree(t, src, []testEntry{
+		{name: "a.txt", body: "file a"},
+		{name: "empty.txt"},
+		{name: "sub/"},
+		{name: "sub/big.bin", body: string(testRandomData(6<<20, 2))},
+		{name: "sub/empty/"},
+		{name: "link", link: "sub/big.bin"},
+	})
+	return repo, src
+}
+
+func TestRepositoryRoundTrip(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	repo, src := testRepository(t, dir)
+	mt := time.Date(2020, time.March, 1, 23, 45, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(src, "sub"), mt, mt); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := repo.backup(src, "docs", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.files != 3 || st.newChunks != st.chunks || st.totalBytes != 6<<20+6 {
+		t.Errorf("backup stats = %+v", st)
+	}
+
+	// The repository is opened again, the same as the repo restore command.
+	if repo, err = openRepository(repo.dir); err != nil {
+		t.Fatal(err)
+	}
+	m, ref, err := repo.snapshot("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != st.snapshotRef {
+		t.Errorf("snapshot = %q, want %q", ref, st.snapshotRef)
+	}
+	dst := filepath.Join(dir, "restore")
+	if _, err := repo.restore(m, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srcFiles, dstFiles := map[string]string{}, map[string]string{}
+	want, got := listTree(t, src, srcFiles), listTree(t, dst, dstFiles)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(dstFiles, srcFiles) {
+		t.Error("the restored files aren't the same as the backed up ones")
+	}
+	for _, name := range []string{"a.txt", "sub/big.bin", "sub"} {
+		a, err1 := os.Stat(filepath.Join(src, name))
+		b, err2 := os.Stat(filepath.Join(dst, name))
+		if err1 != nil || err2 != nil {
+			t.Fatal(err1, err2)
+		}
+		if !a.ModTime().Equal(b.ModTime()) || a.Mode() != b.Mode() {
+			t.Errorf("%s: restored %v %v, want %v %v", name, b.ModTime(), b.Mode(), a.ModTime(), a.Mode())
+		}
+	}
+
+	// A second backup of the same files stores no new chunks.
+	st, err = repo.backup(src, "docs", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.newChunks != 0 || st.newBytes != 0 {
+		t.Errorf("second backup stored %d new chunks, %d bytes", st.newChunks, st.newBytes)
+	}
+	if stamps, err := repo.snapshots("docs"); err != nil || len(stamps) != 2 {
+		t.Errorf("snapshots = %q, %v", stamps, err)
+	}
+}
+
+func TestRepositoryDedup(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	repo, src := testRepository(t, dir)
+	if _, err := repo.backup(src, "docs", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A few bytes are replaced in the middle of the big file.
+	file := filepath.Join(src, "sub", "big.bin")
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[3<<20:], "edited")
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := repo.backup(src, "docs", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.newChunks != 1 || st.newBytes > chunkMaxSize {
+		t.Errorf("the edited backup stored %d new chunks of %d, %d bytes", st.newChunks, st.chunks, st.newBytes)
+	}
+}
+
+func TestRepositoryCorruptChunk(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	repo, src := testRepository(t, dir)
+	if _, err := repo.backup(src, "docs", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := repo.snapshot("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One byte of a chunk of the big file is flipped.
+	var chunk string
+	for _, e := range m.Files {
+		if e.Path == "sub/big.bin" {
+			chunk = e.Chunks[len(e.Chunks)/2]
+		}
+	}
+	data, err := ioutil.ReadFile(repo.chunkPath(chunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := ioutil.WriteFile(repo.chunkPath(chunk), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.restore(m, filepath.Join(dir, "restore"), nil)
+	if err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("restore error = %v, want a corrupted chunk", err)
+	}
+
+	// A missing chunk is an error too.
+	if err := os.Remove(repo.chunkPath(chunk)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.restore(m, filepath.Join(dir, "restore2"), nil); err == nil {
+		t.Error("restore of a missing chunk has no error")
+	}
+}
+
+func TestRepositoryRestoreSymlinks(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+	repo, err := initRepository(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := repo.putChunk([]byte("evil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(dir, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	evil := repoEntry{Path: "l/evil.txt", Type: RepoEntryFile, Mode: 0644, Size: 4, Chunks: []string{sum}}
+
+	// A crafted snapshot with a symlink to the outside folder before a file through it.
+	m := repoManifest{Files: []repoEntry{{Path: "l", Type: RepoEntrySymlink, Target: outside}, evil}}
+	if _, err := repo.restore(m, filepath.Join(dir, "restore"), nil); err == nil {
+		t.Error("restore of a file through a restored symlink has no error")
+	}
+
+	// The symlink is already in the destination folder, e.g. from an earlier restore.
+	dst := filepath.Join(dir, "restore2")
+	writeTestTree(t, dst, []testEntry{{name: "l", link: outside}})
+	m = repoManifest{Files: []repoEntry{evil}}
+	if _, err := repo.restore(m, dst, nil); err == nil || !strings.Contains(err.Error(), "goes through the symlink l") {
+		t.Errorf("restore error = %v, want a file through the symlink", err)
+	}
+
+	if got := listTree(t, outside, nil); len(got) != 0 {
+		t.Errorf("the outside folder has %q", got)
+	}
+}
@@ -20,12 +20,12 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/itrepablik/itrlog"
 
 	"github.com/spf13/cobra"
-	"go.uber.org/zap"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -57,40 +57,52 @@ var IgnoreFT []string
 
 // STCopyDIRF is the copy dir struct for data collection from the 'config.yaml' file.
 type STCopyDIRF struct {
+	name          string
 	src, dst      string
 	runEvery      int
 	interval      string
 	retentionDays int
 	intervalType  string
+	ignore        []string
+	compress      bool
+	snapshot      bool
 }
 
 // STCopyDIRD is the copy dir struct for data collection from the 'config.yaml' file.
 type STCopyDIRD struct {
+	name          string
 	src, dst      string
 	runEvery      int
 	interval      string
 	runAt         string
 	intervalType  string
 	retentionDays int
+	ignore        []string
+	compress      bool
+	snapshot      bool
 }
 
 // STCopyMD is the copymd struct for data collection from the 'config.yaml' file.
 type STCopyMD struct {
+	name           string
 	src, dst       string
 	runEvery       int
 	interval       string
 	runAt          string
 	intervalType   string
 	copyModNumDays string
+	ignore         []string
 }
 
 // STCopyMDF is the copymd struct for data collection from the 'config.yaml' file.
 type STCopyMDF struct {
+	name           string
 	src, dst       string
 	runEvery       int
 	interval       string
 	intervalType   string
 	copyModNumDays string
+	ignore         []string
 }
 
 // MapCopyDIRD is to store copydir_daily automated backup.
@@ -144,6 +156,9 @@ var NumFilesCopied int = 0
 // NumFoldersCopied counts the number of folders copied.
 var NumFoldersCopied int = 0
 
+// DryRun is the global --dry-run flag, the commands only print and log what they would do without writing anything.
+var DryRun bool = false
+
 // MaxLogFileSizeInMB gets the max log file size value in megabytes.
 var MaxLogFileSizeInMB int = 100 // mb
 
@@ -188,6 +203,7 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().BoolVar(&DryRun, "dry-run", false, "print and log what would be done without writing or deleting anything")
 	viper.SetConfigName("config") // name of config file (without extension)
 	viper.AddConfigPath(".")      // optionally look for config in the working directory
 
@@ -210,21 +226,25 @@ func init() {
 	// Set the default values, these will be fetch even though not found in "config.yaml" file.
 	viper.SetDefault("license", "")                         // Set to blank value for the license
 	viper.SetDefault("default.copy_mod_files_num_days", -1) // Set it to 1 day
+	viper.SetDefault("default.snapshot", false)             // Set to true to write each backup into its own timestamped folder.
+	viper.SetDefault("default.incremental", false)          // Set to true to skip the unchanged files of copydir.
+	viper.SetDefault("default.copymd_checksum", false)      // Set to true to skip the files copymd already copied with the same SHA-256 hash.
+	viper.SetDefault("default.comdir_format", FormatTarGz)  // Archive format of the comdir command.
+	viper.SetDefault("default.comfile_format", FormatZip)   // Archive format of the comfile command.
+	viper.SetDefault("default.compression_level", 0)        // 0 is the default compression level of each format.
+	viper.SetDefault("default.compression_threads", 1)      // Threads of the tar.gz and tar.zst compression, -1 for all the CPUs.
 	viper.SetDefault("logging.log_copied_file", true)       // Set to true to log each single file copied.
-	viper.SetDefault("ignore.file_types", ".thumb, .db")    // Set the default common ignored file types.
-	viper.SetDefault("ignore.folders", "")                  // Set the default ignored folder here, leave it blank.
 
 	// Get the default value for the "max_log_file_size_in_mb" setting.
 	maxLogFileSize := viper.Get("logging.max_log_file_size_in_mb")
-	MaxLogFileSizeInMB = maxLogFileSize.(int)
-	if _, ok := maxLogFileSize.(int); !ok {
+	var ok bool
+	if MaxLogFileSizeInMB, ok = maxLogFileSize.(int); !ok {
 		MaxLogFileSizeInMB = 100 // default: mb
 	}
 
 	// Get the default value for the "max_age_in_days" setting.
 	maxLogAge := viper.Get("logging.max_age_in_days")
-	MaxAgeLogInDays = maxLogAge.(int)
-	if _, ok := maxLogAge.(int); !ok {
+	if MaxAgeLogInDays, ok = maxLogAge.(int); !ok {
 		MaxAgeLogInDays = 0 // default: days
 	}
 
@@ -243,14 +263,33 @@ func init() {
 
 	// Get the default value for the "copy_mod_files_num_days" setting.
 	modDays := viper.Get("default.copy_mod_files_num_days")
-	MDays = modDays.(int)
-	if _, ok := modDays.(int); !ok {
+	if MDays, ok = modDays.(int); !ok {
 		MDays = -1
 	}
 
 	viper.WatchConfig() // Tell the viper to watch any new changes to the config file.
 }
 
+// IgnoreList gets the ignore patterns from the 'config.yaml' file, the comma separated
+// ignore.file_type_or_folder_name list then the ignore.patterns list, including the "extra" ones of a
+// structured job. A file extension such as ".db" of the comma separated list means "*.db".
+func IgnoreList(extra []string) []string {
+	IgnoreFileTypes = viper.Get("ignore.file_type_or_folder_name")
+	IgnoreFT = nil
+	for _, ft := range append(strings.Split(fmt.Sprint(IgnoreFileTypes), ","), extra...) {
+		// Skip the blank ones, otherwise it matches and ignores everything.
+		if ft = strings.TrimSpace(ft); ft != "" && ft != "<nil>" {
+			IgnoreFT = append(IgnoreFT, legacyIgnorePattern(ft))
+		}
+	}
+	for _, p := range viper.GetStringSlice("ignore.patterns") {
+		if p = strings.TrimSpace(p); p != "" {
+			IgnoreFT = append(IgnoreFT, p)
+		}
+	}
+	return IgnoreFT
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestMain runs the tests in a temporary working folder, with its own 'config.yaml' file, for the logs and
+// the state files they write.
+func TestMain(m *testing.M) {
+	// The init function creates an empty 'config.yaml' file when there's none, e.g. in the cmd folder.
+	if fi, err := os.Stat("config.yaml"); err == nil && fi.Size() == 0 {
+		os.Remove("config.yaml")
+	}
+
+	dir, err := ioutil.TempDir("", "gokopy_test_")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	viper.SetConfigFile("config.yaml")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
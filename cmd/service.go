@@ -0,0 +1,343 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// backupJob is a single automated backup item scheduled by the "service run" command.
+type backupJob struct {
+	name     string
+	schedule string // e.g. "every 1 monday at 11:30", the reloaded job with the same name and schedule keeps its next run
+	nextRun  time.Time
+	advance  func(t time.Time) time.Time // Returns the following run time after the scheduled run time "t".
+	run      func() error
+}
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run the automated backup items from the 'config.yaml' file",
+	Long: `service command groups the sub-commands to run the automated backup schedules declared in the
+"backups" section of the 'config.yaml' file.`,
+}
+
+// serviceRunCmd represents the service run command
+var serviceRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the automated backup schedules in the foreground",
+	Long: `service run command parses every backup_items entry of the copydir_daily, copydir_frequently, copymd_daily and
+copymd_frequently sections and every structured job of the "jobs" list of the 'config.yaml' file, then runs
+each of them on its schedule until it's stopped with Ctrl+C or a SIGTERM signal.
+
+Example of the backup items:
+
+backups:
+	copydir_daily:
+		backup_items:
+			- src=/root/src, dst=/root/dst, run_every=1, interval=days, run_at=23:30, retention_days=-30; # needs default.snapshot: true
+
+	copymd_frequently:
+		backup_items:
+			- src=/root/src, dst=/root/dst, run_every=30, interval=minutes, modified_days=-1;
+
+Any changes to the 'config.yaml' file will be reloaded automatically without restarting the service,
+use the "config validate" command to check all the backup items before starting the service.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		jobs, err := loadBackupJobs(time.Now())
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return
+		}
+
+		// Reload the backup items whenever the 'config.yaml' file has been changed.
+		reload := make(chan struct{}, 1)
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		})
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+		msg := `Gokopy service has started, number of scheduled backup items:`
+		fmt.Println(msg, len(jobs))
+		Sugar.Infow(msg, "backup_items", len(jobs), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+		runBackupJobs(jobs, reload, sigs)
+
+		msg = `Gokopy service has been stopped.`
+		fmt.Println(msg)
+		Sugar.Infow(msg, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// runBackupJobs runs each backup job on its schedule until a signal has been received.
+func runBackupJobs(jobs []*backupJob, reload <-chan struct{}, sigs <-chan os.Signal) {
+	var timer *time.Timer
+	for {
+		if timer != nil {
+			timer.Stop()
+		}
+
+		var job *backupJob
+		for _, j := range jobs {
+			if job == nil || j.nextRun.Before(job.nextRun) {
+				job = j
+			}
+		}
+
+		// Nothing to run yet, wait for the 'config.yaml' changes or a signal to stop the service.
+		var wait <-chan time.Time
+		if job != nil {
+			msg := `Next scheduled backup item:`
+			fmt.Println(msg, job.name, "at", job.nextRun.Format(itrlog.LogTimeFormat))
+			Sugar.Infow(msg, "name", job.name, "next_run", job.nextRun.Format(itrlog.LogTimeFormat), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+			timer = time.NewTimer(time.Until(job.nextRun))
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+			job.run() // Errors are already reported to the user's console and the logs.
+
+			// Skip the missed schedules when the backup took longer than its own interval.
+			now := time.Now()
+			for !job.nextRun.After(now) {
+				job.nextRun = job.advance(job.nextRun)
+			}
+		case <-reload:
+			newJobs, err := loadBackupJobs(time.Now())
+			if err != nil {
+				// Keep the current schedules until the 'config.yaml' file has been fixed.
+				fmt.Println(err)
+				Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+				continue
+			}
+			keepNextRuns(newJobs, jobs)
+			jobs = newJobs
+
+			msg := `The 'config.yaml' file has been reloaded, number of scheduled backup items:`
+			fmt.Println(msg, len(jobs))
+			Sugar.Infow(msg, "backup_items", len(jobs), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		case s := <-sigs:
+			msg := `Received signal:`
+			fmt.Println(msg, s)
+			Sugar.Infow(msg, "signal", s.String(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			return
+		}
+	}
+}
+
+// keepNextRuns keeps the next run time of the old jobs in the reloaded jobs that have the same name and
+// schedule, so saving the 'config.yaml' file doesn't postpone them.
+func keepNextRuns(jobs, oldJobs []*backupJob) {
+	nextRuns := make(map[string]time.Time)
+	for _, j := range oldJobs {
+		nextRuns[j.name+"\n"+j.schedule] = j.nextRun
+	}
+	for _, j := range jobs {
+		if nextRun, ok := nextRuns[j.name+"\n"+j.schedule]; ok {
+			j.nextRun = nextRun
+		}
+	}
+}
+
+// loadBackupJobs parses all the backup items from the 'config.yaml' file and computes their first run time after "now".
+func loadBackupJobs(now time.Time) ([]*backupJob, error) {
+	if err := LoadBackupItems(); err != nil {
+		return nil, err
+	}
+
+	var jobs []*backupJob
+	for n := 0; n < len(MapCopyDIRD); n++ {
+		CURCopyDIRD = MapCopyDIRD[n]
+		job, err := newDailyJob(CURCopyDIRD.name, now, CURCopyDIRD.runEvery, CURCopyDIRD.interval, CURCopyDIRD.runAt)
+		if err != nil {
+			return nil, err
+		}
+		opts := backupOptions{job: CURCopyDIRD.name, snapshot: CURCopyDIRD.snapshot, ignore: CURCopyDIRD.ignore,
+			incremental: viper.GetBool("default.incremental")}
+		job.run = copyDIRJob(CURCopyDIRD.src, CURCopyDIRD.dst, opts, CURCopyDIRD.compress, CURCopyDIRD.retentionDays)
+		jobs = append(jobs, job)
+	}
+
+	for n := 0; n < len(MapCopyDIRF); n++ {
+		CURCopyDIRF = MapCopyDIRF[n]
+		job, err := newFrequentJob(CURCopyDIRF.name, now, CURCopyDIRF.runEvery, CURCopyDIRF.interval)
+		if err != nil {
+			return nil, err
+		}
+		opts := backupOptions{job: CURCopyDIRF.name, snapshot: CURCopyDIRF.snapshot, ignore: CURCopyDIRF.ignore,
+			incremental: viper.GetBool("default.incremental")}
+		job.run = copyDIRJob(CURCopyDIRF.src, CURCopyDIRF.dst, opts, CURCopyDIRF.compress, CURCopyDIRF.retentionDays)
+		jobs = append(jobs, job)
+	}
+
+	for n := 0; n < len(MapCopyMD); n++ {
+		CURCopyMD = MapCopyMD[n]
+		job, err := newDailyJob(CURCopyMD.name, now, CURCopyMD.runEvery, CURCopyMD.interval, CURCopyMD.runAt)
+		if err != nil {
+			return nil, err
+		}
+		job.run = copyMDJob(CURCopyMD.name, CURCopyMD.src, CURCopyMD.dst, CURCopyMD.copyModNumDays, CURCopyMD.ignore)
+		jobs = append(jobs, job)
+	}
+
+	for n := 0; n < len(MapCopyMDF); n++ {
+		CURCopyMDF = MapCopyMDF[n]
+		job, err := newFrequentJob(CURCopyMDF.name, now, CURCopyMDF.runEvery, CURCopyMDF.interval)
+		if err != nil {
+			return nil, err
+		}
+		job.run = copyMDJob(CURCopyMDF.name, CURCopyMDF.src, CURCopyMDF.dst, CURCopyMDF.copyModNumDays, CURCopyMDF.ignore)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// copyDIRJob returns the run function of a copydir backup item, it compresses the src when "compress" is true
+// and prunes the snapshots older than the retention days after each successful run, only in snapshot mode.
+func copyDIRJob(src, dst string, opts backupOptions, compress bool, retentionDays int) func() error {
+	src, dst = filepath.FromSlash(src), filepath.FromSlash(dst)
+	return func() error {
+		var err error
+		if compress {
+			err = runComDIR(src, dst, opts)
+		} else {
+			err = runCopyDIR(src, dst, opts)
+		}
+		if err != nil || retentionDays >= 0 || !opts.snapshot {
+			return err
+		}
+		_, err = runPrune(backupDir(dst, opts), retentionDays, DryRun)
+		return err
+	}
+}
+
+// copyMDJob returns the run function of a copymd backup item, the job name is its state file name.
+func copyMDJob(name, src, dst, modDays string, ignore []string) func() error {
+	src, dst, mDays := filepath.FromSlash(src), filepath.FromSlash(dst), modDaysOrDefault(modDays)
+	opts := copymdOptions{job: name, checksum: viper.GetBool("default.copymd_checksum")}
+	return func() error { return runCopyMD(src, dst, mDays, ignore, opts) }
+}
+
+// modDaysOrDefault returns the "modified_days" of a copymd backup item or the default "copy_mod_files_num_days" setting.
+func modDaysOrDefault(modDays string) int {
+	if mDays, err := strconv.Atoi(modDays); err == nil {
+		return mDays
+	}
+	return MDays
+}
+
+// newDailyJob schedules a copydir_daily or copymd_daily backup item, e.g. run_every=1, interval=monday, run_at=11:30
+// runs every monday at 11:30 AM, while run_every=2, interval=days runs every other day.
+func newDailyJob(name string, now time.Time, runEvery int, interval, runAt string) (*backupJob, error) {
+	if runEvery < 1 {
+		return nil, fmt.Errorf("%s: run_every must be at least 1", name)
+	}
+	at, err := time.Parse("15:04", runAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid run_at value %q, it must be in HH:MM format", name, runAt)
+	}
+
+	days := runEvery
+	nextRun := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	interval = strings.ToLower(interval)
+	if interval != "days" {
+		weekday, ok := weekdays[interval]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown interval %q", name, interval)
+		}
+		days = runEvery * 7
+		nextRun = nextRun.AddDate(0, 0, (int(weekday)-int(nextRun.Weekday())+7)%7)
+	}
+	if !nextRun.After(now) {
+		if interval == "days" {
+			nextRun = nextRun.AddDate(0, 0, 1)
+		} else {
+			nextRun = nextRun.AddDate(0, 0, 7)
+		}
+	}
+
+	return &backupJob{
+		name:     name,
+		schedule: fmt.Sprintf("every %d %s at %s", runEvery, interval, at.Format("15:04")),
+		nextRun:  nextRun,
+		advance:  func(t time.Time) time.Time { return t.AddDate(0, 0, days) },
+	}, nil
+}
+
+// newFrequentJob schedules a copydir_frequently or copymd_frequently backup item, e.g. run_every=30, interval=minutes.
+func newFrequentJob(name string, now time.Time, runEvery int, interval string) (*backupJob, error) {
+	if runEvery < 1 {
+		return nil, fmt.Errorf("%s: run_every must be at least 1", name)
+	}
+	unit, ok := frequentUnits[strings.ToLower(interval)]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown interval %q", name, interval)
+	}
+
+	every := time.Duration(runEvery) * unit
+	return &backupJob{
+		name:     name,
+		schedule: fmt.Sprintf("every %d %s", runEvery, strings.ToLower(interval)),
+		nextRun:  now.Add(every),
+		advance:  func(t time.Time) time.Time { return t.Add(every) },
+	}, nil
+}
+
+// weekdays maps the copydir_daily and copymd_daily interval options to its week day.
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// frequentUnits maps the copydir_frequently and copymd_frequently interval options to its duration.
+var frequentUnits = map[string]time.Duration{
+	"seconds": time.Second,
+	"minutes": time.Minute,
+	"hours":   time.Hour,
+}
+
+func init() {
+	serviceCmd.AddCommand(serviceRunCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
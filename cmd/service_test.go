@@ -0,0 +1,269 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDailyJob(t *testing.T) {
+	// Wednesday, June 3, 2020 at 10:00 AM.
+	now := time.Date(2020, time.June, 3, 10, 0, 0, 0, time.UTC)
+	day := func(d, h, m int) time.Time { return time.Date(2020, time.June, d, h, m, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		runEvery int
+		interval string
+		runAt    string
+		nextRun  time.Time
+		advance  time.Time // The run after nextRun
+		err      string
+	}{
+		{name: "later today", runEvery: 1, interval: "days", runAt: "11:45", nextRun: day(3, 11, 45), advance: day(4, 11, 45)},
+		{name: "earlier today", runEvery: 1, interval: "days", runAt: "09:30", nextRun: day(4, 9, 30), advance: day(5, 9, 30)},
+		{name: "right now", runEvery: 1, interval: "days", runAt: "10:00", nextRun: day(4, 10, 0), advance: day(5, 10, 0)},
+		{name: "every other day", runEvery: 2, interval: "days", runAt: "23:30", nextRun: day(3, 23, 30), advance: day(5, 23, 30)},
+		{name: "upper case days", runEvery: 1, interval: "Days", runAt: "00:00", nextRun: day(4, 0, 0), advance: day(5, 0, 0)},
+		{name: "next monday", runEvery: 1, interval: "monday", runAt: "11:30", nextRun: day(8, 11, 30), advance: day(15, 11, 30)},
+		{name: "friday this week", runEvery: 1, interval: "Friday", runAt: "08:00", nextRun: day(5, 8, 0), advance: day(12, 8, 0)},
+		{name: "wednesday later today", runEvery: 1, interval: "wednesday", runAt: "11:00", nextRun: day(3, 11, 0), advance: day(10, 11, 0)},
+		{name: "wednesday earlier today", runEvery: 1, interval: "wednesday", runAt: "09:00", nextRun: day(10, 9, 0), advance: day(17, 9, 0)},
+		{name: "every other sunday", runEvery: 2, interval: "sunday", runAt: "06:15", nextRun: day(7, 6, 15), advance: day(21, 6, 15)},
+		{name: "zero run_every", runEvery: 0, interval: "days", runAt: "11:45", err: "run_every must be at least 1"},
+		{name: "negative run_every", runEvery: -1, interval: "monday", runAt: "11:45", err: "run_every must be at least 1"},
+		{name: "out of range run_at", runEvery: 1, interval: "days", runAt: "25:00", err: `invalid run_at value "25:00"`},
+		{name: "dotted run_at", runEvery: 1, interval: "days", runAt: "11.30", err: `invalid run_at value "11.30"`},
+		{name: "missing run_at", runEvery: 1, interval: "days", runAt: "", err: `invalid run_at value ""`},
+		{name: "frequent interval", runEvery: 1, interval: "hours", runAt: "11:45", err: `unknown interval "hours"`},
+		{name: "unknown week day", runEvery: 1, interval: "mon", runAt: "11:45", err: `unknown interval "mon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := newDailyJob("job", now, tt.runEvery, tt.interval, tt.runAt)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				if !strings.HasPrefix(err.Error(), "job: ") {
+					t.Errorf("error %q doesn't start with the job name", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !job.nextRun.Equal(tt.nextRun) {
+				t.Errorf("nextRun = %v, want %v", job.nextRun, tt.nextRun)
+			}
+			if got := job.advance(job.nextRun); !got.Equal(tt.advance) {
+				t.Errorf("advance = %v, want %v", got, tt.advance)
+			}
+		})
+	}
+}
+
+func TestNewFrequentJob(t *testing.T) {
+	now := time.Date(2020, time.June, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		runEvery int
+		interval string
+		every    time.Duration
+		err      string
+	}{
+		{name: "seconds", runEvery: 15, interval: "seconds", every: 15 * time.Second},
+		{name: "minutes", runEvery: 30, interval: "minutes", every: 30 * time.Minute},
+		{name: "hours", runEvery: 2, interval: "hours", every: 2 * time.Hour},
+		{name: "upper case", runEvery: 1, interval: "Minutes", every: time.Minute},
+		{name: "zero run_every", runEvery: 0, interval: "seconds", err: "run_every must be at least 1"},
+		{name: "daily interval", runEvery: 1, interval: "days", err: `unknown interval "days"`},
+		{name: "singular unit", runEvery: 1, interval: "minute", err: `unknown interval "minute"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := newFrequentJob("job", now, tt.runEvery, tt.interval)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := now.Add(tt.every); !job.nextRun.Equal(want) {
+				t.Errorf("nextRun = %v, want %v", job.nextRun, want)
+			}
+			if got, want := job.advance(job.nextRun), now.Add(2*tt.every); !got.Equal(want) {
+				t.Errorf("advance = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunBackupJobs(t *testing.T) {
+	// testJob sends its name to "runs" after each run that takes the "takes" duration.
+	testJob := func(name string, nextRun time.Time, every, takes time.Duration, runs chan<- string) *backupJob {
+		return &backupJob{
+			name:    name,
+			nextRun: nextRun,
+			advance: func(t time.Time) time.Time { return t.Add(every) },
+			run: func() error {
+				time.Sleep(takes)
+				runs <- name
+				return nil
+			},
+		}
+	}
+	ms := time.Millisecond
+
+	tests := []struct {
+		name   string
+		jobs   func(now time.Time, runs chan<- string) []*backupJob
+		config string // The 'config.yaml' file reloaded after the first run, when it's not empty
+		want   []string
+		quiet  bool // Nothing else must run after the wanted runs
+	}{
+		{
+			name: "earliest first",
+			jobs: func(now time.Time, runs chan<- string) []*backupJob {
+				return []*backupJob{
+					testJob("hourly", now.Add(80*ms), time.Hour, 0, runs),
+					testJob("often", now.Add(20*ms), 40*ms, 0, runs),
+				}
+			},
+			want: []string{"often", "often", "hourly", "often"},
+		},
+		{
+			// The 1st run of "slow" ends at 65ms, its next run is at 70ms instead of 20ms and ends after "other" is due.
+			name: "missed schedules are skipped",
+			jobs: func(now time.Time, runs chan<- string) []*backupJob {
+				return []*backupJob{
+					testJob("slow", now.Add(10*ms), 10*ms, 55*ms, runs),
+					testJob("other", now.Add(100*ms), time.Hour, 0, runs),
+				}
+			},
+			want: []string{"slow", "slow", "other"},
+		},
+		{
+			name: "invalid config keeps the jobs",
+			jobs: func(now time.Time, runs chan<- string) []*backupJob {
+				return []*backupJob{testJob("often", now.Add(10*ms), 50*ms, 0, runs)}
+			},
+			config: "backups:\n  copydir_frequently:\n    backup_items:\n      - src=a, dst=b, run_every=0, interval=seconds;\n",
+			want:   []string{"often", "often"},
+		},
+		{
+			name: "reload replaces the jobs",
+			jobs: func(now time.Time, runs chan<- string) []*backupJob {
+				return []*backupJob{testJob("often", now.Add(10*ms), 50*ms, 0, runs)}
+			},
+			config: "backups:\n  copydir_frequently:\n    backup_items:\n      - \n",
+			want:   []string{"often"},
+			quiet:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != "" {
+				if err := ioutil.WriteFile("config.yaml", []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove("config.yaml")
+			}
+
+			runs := make(chan string, 10)
+			reload := make(chan struct{}, 1)
+			sigs := make(chan os.Signal, 1)
+			done := make(chan struct{})
+			go func() {
+				runBackupJobs(tt.jobs(time.Now(), runs), reload, sigs)
+				close(done)
+			}()
+
+			var got []string
+			timeout := time.After(2 * time.Second)
+			for len(got) < len(tt.want) {
+				select {
+				case name := <-runs:
+					got = append(got, name)
+					if len(got) == 1 && tt.config != "" {
+						reload <- struct{}{}
+					}
+				case <-timeout:
+					t.Fatalf("runs = %v, want %v", got, tt.want)
+				}
+			}
+			if tt.quiet {
+				select {
+				case name := <-runs:
+					got = append(got, name)
+				case <-time.After(200 * ms):
+				}
+			}
+			sigs <- os.Interrupt
+			<-done
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("runs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepNextRuns(t *testing.T) {
+	now := time.Date(2020, time.June, 3, 10, 0, 0, 0, time.UTC)
+	job := func(name string, runEvery int, interval string, at time.Time) *backupJob {
+		j, err := newFrequentJob(name, at, runEvery, interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	daily := func(name, runAt string, at time.Time) *backupJob {
+		j, err := newDailyJob(name, at, 1, "days", runAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	// The old jobs were loaded an hour earlier.
+	oldJobs := []*backupJob{job("same", 2, "hours", now.Add(-time.Hour)), job("changed", 2, "hours", now.Add(-time.Hour)),
+		job("renamed", 2, "hours", now.Add(-time.Hour)), daily("daily", "09:30", now.Add(-time.Hour))}
+	jobs := []*backupJob{job("same", 2, "Hours", now), job("changed", 3, "hours", now), job("new name", 2, "hours", now),
+		job("added", 1, "hours", now), daily("daily", "9:30", now)}
+	keepNextRuns(jobs, oldJobs)
+
+	want := map[string]time.Time{
+		"same":     now.Add(time.Hour),
+		"changed":  now.Add(3 * time.Hour),
+		"new name": now.Add(2 * time.Hour),
+		"added":    now.Add(time.Hour),
+		"daily":    time.Date(2020, time.June, 3, 9, 30, 0, 0, time.UTC), // "9:30" is the same run_at as "09:30"
+	}
+	for _, j := range jobs {
+		if !j.nextRun.Equal(want[j.name]) {
+			t.Errorf("%s: nextRun = %v, want %v", j.name, j.nextRun, want[j.name])
+		}
+	}
+}
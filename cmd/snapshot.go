@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itrepablik/kopy"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// LatestSnapshot is the name of the symlink that points at the newest snapshot of a job.
+const LatestSnapshot = "latest"
+
+// backupOptions holds the per-run settings of the copydir, copyfile and comdir operations.
+type backupOptions struct {
+	job         string          // Name of the job folder of the snapshots, dst/<job>/<YYYY-MM-DD_HHMMSS>
+	snapshot    bool            // Write each run into its own timestamped snapshot instead of replacing dst
+	ignore      []string        // Added to the ignore list of the 'config.yaml' file
+	checksum    bool            // Compare the unchanged files by their SHA-256 hash as well, copydir only
+	verify      bool            // Re-read every copied file and compare its hash with the source, copyfile and copydir only
+	incremental bool            // Skip the files that haven't changed in dst, copydir only
+	mirror      bool            // Delete the files of dst that aren't in the source, copydir only
+	quarantine  string          // Move the files removed by the mirror mode into this folder instead of deleting them
+	deleteLimit string          // Abort the mirror mode when it would remove more than "N" files or "N%" of the files
+	compress    compressOptions // Archive format, level and threads, comdir only
+	filters     filterFlags     // Size, modification time and extension filters, copydir and comdir only
+}
+
+// addSnapshotFlags registers the --snapshot and --job flags of the copydir, copyfile and comdir commands.
+func addSnapshotFlags(cmd *cobra.Command, opts *backupOptions) {
+	cmd.Flags().BoolVar(&opts.snapshot, "snapshot", false, `write each run into dst/<job>/<YYYY-MM-DD_HHMMSS> with a "latest" symlink (default from default.snapshot)`)
+	cmd.Flags().StringVar(&opts.job, "job", "", "job folder name of the snapshots (default is the src base name)")
+}
+
+// resolveSnapshotFlags applies the 'config.yaml' default.snapshot setting when --snapshot isn't set,
+// and names the job folder after the src when --job isn't set.
+func resolveSnapshotFlags(cmd *cobra.Command, opts *backupOptions, src string) {
+	if !cmd.Flags().Changed("snapshot") {
+		opts.snapshot = viper.GetBool("default.snapshot")
+	}
+	if opts.job == "" {
+		opts.job = kopy.FileNameWOExt(filepath.Base(src))
+	}
+}
+
+// backupDir returns the folder where the dated backups of a run are written, it's the job folder in snapshot mode.
+func backupDir(dst string, opts backupOptions) string {
+	if opts.snapshot {
+		return filepath.Join(dst, opts.job)
+	}
+	return dst
+}
+
+// snapshotTime returns the timestamp of a new snapshot in the job folder, it moves to the next second
+// when a snapshot with the same timestamp already exists.
+func snapshotTime(jobDir string, pattern func(stamp string) string) string {
+	t := time.Now()
+	for {
+		stamp := t.Format(BackupTimeFormat)
+		if _, err := os.Lstat(filepath.Join(jobDir, pattern(stamp))); os.IsNotExist(err) {
+			return stamp
+		}
+		t = t.Add(time.Second)
+	}
+}
+
+// newSnapshotDir creates a new timestamped snapshot folder, dst/<job>/<YYYY-MM-DD_HHMMSS>, it only returns
+// its path on a dry run.
+func newSnapshotDir(dst, job string) (string, error) {
+	jobDir := filepath.Join(dst, job)
+	if DryRun {
+		// The timestamped folder it would create.
+		return filepath.Join(jobDir, snapshotTime(jobDir, func(stamp string) string { return stamp })), nil
+	}
+	if err := os.MkdirAll(jobDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	stamp := snapshotTime(jobDir, func(stamp string) string { return stamp })
+	snapDir := filepath.Join(jobDir, stamp)
+	if err := os.Mkdir(snapDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	return snapDir, nil
+}
+
+// latestSnapshot returns the snapshot folder the "latest" symlink of the job folder points at, or the newest
+// dated snapshot folder when there's no "latest" symlink. It's empty when the job has no snapshots yet.
+func latestSnapshot(jobDir string) string {
+	if target, err := os.Readlink(filepath.Join(jobDir, LatestSnapshot)); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(jobDir, target)
+		}
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			return target
+		}
+	}
+
+	backups, _ := datedBackups(jobDir)
+	for i := len(backups) - 1; i >= 0; i-- {
+		if info, err := os.Stat(backups[i].path); err == nil && info.IsDir() {
+			return backups[i].path
+		}
+	}
+	return ""
+}
+
+// updateLatest points the "latest" symlink of the job folder at its newest snapshot, it's replaced
+// atomically so the "latest" symlink is never missing.
+func updateLatest(snapshot string) error {
+	jobDir := filepath.Dir(snapshot)
+	tmp := filepath.Join(jobDir, LatestSnapshot+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(snapshot), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(jobDir, LatestSnapshot))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+)
+
+// StateDir is the folder of the copymd job state files, in the working directory next to the "logs" folder.
+const StateDir = "state"
+
+// copymdState is the state file of a copymd job, "state/<job>.json", the next run copies the files
+// changed since its last successful run.
+type copymdState struct {
+	Job     string              `json:"job"`
+	Src     string              `json:"src"`             // Absolute path of the src folder
+	Dst     string              `json:"dst"`             // Absolute path of the dst folder
+	LastRun time.Time           `json:"last_run"`        // Start time of the last successful run
+	Files   map[string]fileHash `json:"files,omitempty"` // SHA-256 hash of the files by their slash separated path, checksum mode only
+}
+
+// fileHash is the size and SHA-256 hash of a file copied by copymd.
+type fileHash struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// stateFile returns the path of the state file of the job.
+func stateFile(job string) string {
+	return filepath.Join(StateDir, job+".json")
+}
+
+// readCopymdState reads the state file of the job, it returns nil when the job has never run successfully.
+func readCopymdState(job string) (*copymdState, error) {
+	data, err := ioutil.ReadFile(stateFile(job))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &copymdState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// loadJobState returns the state of the copymd job, it's nil on the first run of the job or when the
+// state file is of other src or dst folders or can't be read, then the job copies the files of the
+// last "mDays" days again.
+func loadJobState(job, src, dst string) *copymdState {
+	if job == "" {
+		return nil
+	}
+	src, dst = absPath(src), absPath(dst)
+	st, err := readCopymdState(job)
+	if err != nil {
+		fmt.Println("The state file of the job can't be read, it's started over:", err)
+		Sugar.Errorw("error", "job", job, "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return nil
+	}
+	if st != nil && (st.Src != src || st.Dst != dst) {
+		msg := `The state file of the job is of other folders, it's started over:`
+		fmt.Println(msg, stateFile(job))
+		Sugar.Infow(msg, "job", job, "state_src", st.Src, "state_dst", st.Dst, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+		return nil
+	}
+	return st
+}
+
+// absPath returns the absolute path of the folder, or the path as it is when it can't be resolved.
+func absPath(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// write saves the state file of the job, a partial state file is never left behind.
+func (st *copymdState) write() error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StateDir, os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(stateFile(st.Job), data)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check the integrity of an archive",
+	Long: `test command reads the entire archive without extracting anything, the checksums of its format are
+checked while it's read: the CRC-32 of every zip entry, the gzip CRC-32, or the checksums of the tar.zst,
+tar.xz and tar.lz4 streams. When the archive has an embedded manifest, every file is compared with its
+size and SHA-256 hash too.
+
+The exit status is non-zero when the archive is corrupt, so a scheduled task can alert about it, example:
+"D:\backup\documents.tar.gz"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := filepath.FromSlash(args[0])
+
+		msg := `Start testing the archive:`
+		fmt.Println(msg, src)
+		Sugar.Infow(msg, "src", src, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+		st, err := testArchive(src)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		if st.errors > 0 {
+			msg = `The archive is corrupt:`
+			fmt.Println(msg, src, " Format: ", st.format.name, " Number of Files: ", st.files, " Errors: ", st.errors)
+			Sugar.Errorw(msg, "src", src, "format", st.format.name, "files", st.files, "errors", st.errors, "manifest", st.manifest,
+				"log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg = `The archive is OK:`
+		fmt.Println(msg, src, " Format: ", st.format.name, " Number of Files: ", st.files, " Manifest: ", st.manifest)
+		Sugar.Infow(msg, "src", src, "format", st.format.name, "files", st.files, "manifest", st.manifest,
+			"log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// archiveTestStats is the result of an archive integrity test.
+type archiveTestStats struct {
+	format   *archiveFormat
+	files    int
+	errors   int
+	manifest bool
+}
+
+// testedFile is a file read from an archive.
+type testedFile struct {
+	size   int64
+	sha256 string
+}
+
+// testArchive reads every entry of the archive, the corrupt entries are reported and counted as errors.
+// The error is only returned when the archive can't be opened.
+func testArchive(src string) (archiveTestStats, error) {
+	var st archiveTestStats
+	ar, format, err := openArchive(src)
+	if err != nil {
+		return st, err
+	}
+	defer ar.Close()
+	st.format = format
+
+	var m *manifest
+	files := map[string]testedFile{}
+	for {
+		e, r, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The tar stream can't be read any further once it's corrupt.
+			st.errors++
+			reportCorrupt(src, err)
+			break
+		}
+
+		name := strings.TrimPrefix(strings.ReplaceAll(e.name, `\`, "/"), "./")
+		if isManifestEntry(name) {
+			if m, err = readManifest(r); err != nil {
+				st.errors++
+				reportCorrupt(name, err)
+			}
+			continue
+		}
+		if !e.mode.IsRegular() {
+			continue
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		switch {
+		case err != nil:
+			st.errors++
+			reportCorrupt(name, err)
+			continue
+		case n != e.size:
+			st.errors++
+			reportCorrupt(name, fmt.Errorf("read %d bytes, its size is %d", n, e.size))
+			continue
+		}
+		st.files++
+		files[name] = testedFile{size: n, sha256: hex.EncodeToString(h.Sum(nil))}
+	}
+
+	if m != nil {
+		st.manifest = true
+		st.errors += compareManifest(m, files)
+	}
+	return st, nil
+}
+
+// compareManifest reports the files that are missing from the archive, differ from the manifest or aren't
+// in the manifest, it returns the number of errors.
+func compareManifest(m *manifest, files map[string]testedFile) int {
+	errors := 0
+	listed := map[string]bool{}
+	for _, me := range m.Files {
+		listed[me.Path] = true
+		f, ok := files[me.Path]
+		switch {
+		case !ok:
+			errors++
+			reportCorrupt(me.Path, fmt.Errorf("missing from the archive"))
+		case f.size != me.Size:
+			errors++
+			reportCorrupt(me.Path, fmt.Errorf("size is %d, the manifest has %d", f.size, me.Size))
+		case f.sha256 != me.SHA256:
+			errors++
+			reportCorrupt(me.Path, fmt.Errorf("SHA-256 doesn't match the manifest"))
+		}
+	}
+	for name := range files {
+		if !listed[name] {
+			errors++
+			reportCorrupt(name, fmt.Errorf("not in the manifest"))
+		}
+	}
+	return errors
+}
+
+// reportCorrupt reports a corrupt entry of the tested archive.
+func reportCorrupt(name string, reason error) {
+	fmt.Println("corrupt:", name, "-", reason)
+	Sugar.Errorw("corrupt", "entry", name, "reason", reason.Error(), "log_time", time.Now().Format(itrlog.LogTimeFormat))
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright © 2020 ITRepablik <support@itrepablik.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itrepablik/itrlog"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyChecksum is the --checksum flag of the verify command.
+var verifyChecksum bool
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Compare a source folder with its backup",
+	Long: `verify command walks both the source folder and its backup, e.g. the destination folder of copydir,
+and compares every file by its size and modification time, and by its SHA-256 hash as well with the
+--checksum flag. The ignore list of the 'config.yaml' file applies the same as the copydir command.
+
+The files that are missing from the backup, the extra files of the backup and the files that differ are
+printed and logged, the exit status is non-zero when there's any of them, example:
+"C:\source_folder" "D:\backup_destination" --checksum`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src := filepath.FromSlash(args[0])
+		dst := filepath.FromSlash(args[1])
+
+		// Get the list of ignored file types.
+		IgnoreFT = IgnoreList(nil)
+
+		msg := `Start verifying the backup of the folder:`
+		fmt.Println(msg, src)
+		Sugar.Infow(msg, "src", src, "dst", dst, "checksum", verifyChecksum, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+
+		st, err := verifyTree(src, dst, verifyChecksum, IgnoreFT)
+		if err != nil {
+			fmt.Println(err)
+			Sugar.Errorw("error", "err", err, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+		if st.missing+st.extra+st.differ > 0 {
+			msg = `The backup doesn't match the folder:`
+			fmt.Println(msg, src, " Number of Files Checked: ", st.files, " Missing: ", st.missing, " Extra: ", st.extra, " Differ: ", st.differ)
+			Sugar.Errorw(msg, "src", src, "dst", dst, "files", st.files, "missing", st.missing, "extra", st.extra, "differ", st.differ,
+				"log_time", time.Now().Format(itrlog.LogTimeFormat))
+			os.Exit(1)
+		}
+
+		msg = `The backup matches the folder:`
+		fmt.Println(msg, src, " Number of Files Checked: ", st.files)
+		Sugar.Infow(msg, "src", src, "dst", dst, "files", st.files, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+	},
+}
+
+// verifyStats counts the files of a verifyTree run.
+type verifyStats struct {
+	files   int // Files found in both folders
+	missing int // Files or folders of src that aren't in dst
+	extra   int // Files or folders of dst that aren't in src
+	differ  int // Files that don't have the same size, modification time or contents
+}
+
+// verifyTree compares the files of the src and dst folders, every difference is reported.
+func verifyTree(src, dst string, checksum bool, ignore []string) (verifyStats, error) {
+	var st verifyStats
+	srcFiles, err := treeFiles(src, ignore)
+	if err != nil {
+		return st, err
+	}
+	dstFiles, err := treeFiles(dst, ignore)
+	if err != nil {
+		return st, err
+	}
+	delete(dstFiles, ManifestName) // The sidecar manifest of copydir and copymd.
+
+	for _, rel := range sortedKeys(srcFiles) {
+		s := srcFiles[rel]
+		d, ok := dstFiles[rel]
+		switch {
+		case !ok:
+			st.missing++
+			reportDiff("missing", rel, "")
+			continue
+		case s.IsDir() != d.IsDir():
+			st.differ++
+			reportDiff("differs", rel, "a folder and a file")
+			continue
+		case s.IsDir():
+			continue
+		}
+
+		st.files++
+		reason := ""
+		switch {
+		case s.Size() != d.Size():
+			reason = fmt.Sprintf("size %d, backup size %d", s.Size(), d.Size())
+		case s.ModTime().Unix() != d.ModTime().Unix():
+			reason = fmt.Sprintf("modified %s, backup modified %s", s.ModTime().Format(itrlog.LogTimeFormat), d.ModTime().Format(itrlog.LogTimeFormat))
+		case checksum:
+			srcSum, err := fileSHA256(filepath.Join(src, rel))
+			if err != nil {
+				return st, err
+			}
+			dstSum, err := fileSHA256(filepath.Join(dst, rel))
+			if err != nil {
+				return st, err
+			}
+			if srcSum != dstSum {
+				reason = "SHA-256 doesn't match"
+			}
+		}
+		if reason != "" {
+			st.differ++
+			reportDiff("differs", rel, reason)
+		}
+	}
+
+	for _, rel := range sortedKeys(dstFiles) {
+		if _, ok := srcFiles[rel]; !ok {
+			st.extra++
+			reportDiff("extra", rel, "")
+		}
+	}
+	return st, nil
+}
+
+// treeFiles returns the folders and files of the root folder by their path relative to it, except the
+// ignored ones. The symlinks are followed the same as copydir copies them.
+func treeFiles(root string, ignore []string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	ig := newIgnoreMatcher(root, ignore)
+	err := filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == root {
+			return nil
+		}
+		if ig.match(file, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(file); err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+	return files, err
+}
+
+// sortedKeys returns the paths of the files in order.
+func sortedKeys(files map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reportDiff reports a file that's missing, extra or differs between the folder and its backup.
+func reportDiff(kind, rel, reason string) {
+	line := []string{kind + ":", rel}
+	if reason != "" {
+		line = append(line, "-", reason)
+	}
+	fmt.Println(strings.Join(line, " "))
+	Sugar.Errorw(kind, "file", rel, "reason", reason, "log_time", time.Now().Format(itrlog.LogTimeFormat))
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyChecksum, "checksum", false, "compare the contents of the files by their SHA-256 hash as well")
+}
@@ -1,12 +1,19 @@
 module gokopy
 
-go 1.13
+go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/itrepablik/itrlog v0.0.0-20200229031045-09c34d1cfed1
 	github.com/itrepablik/kopy v0.0.0-20200302010442-febda39b22ce
+	github.com/klauspost/compress v1.11.13
+	github.com/klauspost/pgzip v1.2.6
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/spf13/cobra v0.0.6
 	github.com/spf13/viper v1.6.2
+	github.com/ulikunitz/xz v0.5.15
 	go.uber.org/zap v1.14.0
+	golang.org/x/sys v0.0.0-20190412213103-97732733099d
+	gopkg.in/yaml.v3 v3.0.1
 )